@@ -1,6 +1,6 @@
-import React, { createContext, useContext, useState, useEffect } from 'react';
+import React, { createContext, useContext, useState, useEffect, useCallback } from 'react';
 import { apiService, ApiError } from '@/lib/apiService';
-import { API_CONFIG } from '@/lib/api';
+import { API_CONFIG, buildApiUrl } from '@/lib/api';
 
 // Safe localStorage utilities
 const safeLocalStorageGet = (key: string): string | null => {
@@ -30,6 +30,16 @@ const safeLocalStorageRemove = (key: string): void => {
   }
 };
 
+// Message shown when the server refuses a login after too many failures
+const tooManyAttemptsMessage = (response: Response): string => {
+  const seconds = parseInt(response.headers.get('Retry-After') || '', 10);
+  if (!seconds) {
+    return 'Too many failed attempts. Please try again later.';
+  }
+  const wait = seconds < 60 ? `${seconds} seconds` : `${Math.ceil(seconds / 60)} minutes`;
+  return `Too many failed attempts. Please try again in ${wait}.`;
+};
+
 interface User {
   id: string;
   email: string;
@@ -39,7 +49,9 @@ interface User {
 interface AuthContextType {
   user: User | null;
   token: string | null;
-  login: (username: string, password: string) => Promise<{ success: boolean; error?: string }>;
+  login: (username: string, password: string) => Promise<{ success: boolean; error?: string; mfaToken?: string }>;
+  loginSecondFactor: (mfaToken: string, code: string) => Promise<{ success: boolean; error?: string; expired?: boolean }>;
+  completeSsoLogin: () => Promise<{ success: boolean; error?: string }>;
   logout: () => void;
   handleTokenExpiration: () => void;
   isLoading: boolean;
@@ -104,7 +116,7 @@ export const AuthProvider: React.FC<{ children: React.ReactNode }> = ({ children
     setIsLoading(false);
   }, []);
 
-  const login = async (username: string, password: string): Promise<{ success: boolean; error?: string }> => {
+  const login = async (username: string, password: string): Promise<{ success: boolean; error?: string; mfaToken?: string }> => {
     try {
       // If using mock data, simulate successful login
       if (typeof __USE_MOCK_DATA__ !== 'undefined' && __USE_MOCK_DATA__) {
@@ -152,6 +164,9 @@ export const AuthProvider: React.FC<{ children: React.ReactNode }> = ({ children
         if (response.status === 401) {
           return { success: false, error: 'Invalid username or password' };
         }
+        if (response.status === 429) {
+          return { success: false, error: tooManyAttemptsMessage(response) };
+        }
         
         // Tentative de récupération du message d'erreur du serveur
         try {
@@ -164,6 +179,12 @@ export const AuthProvider: React.FC<{ children: React.ReactNode }> = ({ children
       }
 
       const data = await response.json();
+
+      // Accounts with two-factor authentication must send a code before getting a token
+      if (data.mfa_required) {
+        return { success: false, mfaToken: data.mfa_token };
+      }
+
       const { token, user } = data;
 
       // Store token and user data
@@ -200,7 +221,74 @@ export const AuthProvider: React.FC<{ children: React.ReactNode }> = ({ children
     }
   };
 
+  // Second step of a login with two-factor authentication: a TOTP code or a recovery code
+  const loginSecondFactor = async (mfaToken: string, code: string): Promise<{ success: boolean; error?: string; expired?: boolean }> => {
+    try {
+      const response = await fetch(buildApiUrl(API_CONFIG.ENDPOINTS.LOGIN_2FA), {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({ mfa_token: mfaToken, code }),
+      });
+      if (!response.ok) {
+        if (response.status === 429) {
+          return { success: false, error: tooManyAttemptsMessage(response) };
+        }
+        const errorData = await response.json().catch(() => ({}));
+        if (errorData.code === 'MFA_TOKEN_INVALID') {
+          return { success: false, expired: true, error: 'Your login expired. Please enter your password again.' };
+        }
+        return { success: false, error: 'Invalid code' };
+      }
+      const { token, user } = await response.json();
+
+      safeLocalStorageSet('auth_token', token);
+      safeLocalStorageSet('auth_user', JSON.stringify(user));
+      setToken(token);
+      setUser(user);
+
+      return { success: true };
+    } catch {
+      return { success: false, error: 'Unable to connect to server. Please check your connection.' };
+    }
+  };
+
+  // After a single sign-on, the server has set the session cookies:
+  // the access token and the user are read from the refresh endpoint
+  const completeSsoLogin = useCallback(async (): Promise<{ success: boolean; error?: string }> => {
+    try {
+      const response = await fetch(buildApiUrl(API_CONFIG.ENDPOINTS.REFRESH), {
+        method: 'POST',
+        credentials: 'include',
+      });
+      if (!response.ok) {
+        return { success: false, error: 'Single sign-on failed' };
+      }
+      const { token, user } = await response.json();
+
+      safeLocalStorageSet('auth_token', token);
+      safeLocalStorageSet('auth_user', JSON.stringify(user));
+      setToken(token);
+      setUser(user);
+
+      return { success: true };
+    } catch {
+      return { success: false, error: 'Unable to connect to server. Please check your connection.' };
+    }
+  }, []);
+
   const logout = () => {
+    // Close the session on the server, which also clears the authentication cookies
+    if (!(typeof __USE_MOCK_DATA__ !== 'undefined' && __USE_MOCK_DATA__)) {
+      fetch(buildApiUrl(API_CONFIG.ENDPOINTS.LOGOUT), {
+        method: 'POST',
+        credentials: 'include',
+        headers: token ? { 'Authorization': `Bearer ${token}` } : {},
+      }).catch(() => {
+        // The local session is closed anyway
+      });
+    }
     // In mock mode, still allow logout but user will be auto-logged back in on refresh
     safeLocalStorageRemove('auth_token');
     safeLocalStorageRemove('auth_user');
@@ -217,7 +305,7 @@ export const AuthProvider: React.FC<{ children: React.ReactNode }> = ({ children
   };
 
   return (
-    <AuthContext.Provider value={{ user, token, login, logout, handleTokenExpiration, isLoading }}>
+    <AuthContext.Provider value={{ user, token, login, loginSecondFactor, completeSsoLogin, logout, handleTokenExpiration, isLoading }}>
       {children}
     </AuthContext.Provider>
   );
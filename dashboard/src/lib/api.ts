@@ -5,6 +5,11 @@ export const API_CONFIG = {
   BASE_URL: import.meta.env.DEV ? '' : (import.meta.env.VITE_API_BASE_URL || ''),
   ENDPOINTS: {
     LOGIN: '/dashdata/login',
+    LOGIN_2FA: '/dashdata/login/2fa',
+    REFRESH: '/dashdata/refresh',
+    LOGOUT: '/dashdata/logout',
+    OIDC_STATUS: '/dashdata/oidc',
+    OIDC_LOGIN: '/dashdata/oidc/login',
     DASHBOARD: '/dashdata/data',
     REPORT_LICENSES: (month: string) => `/dashdata/report-licenses?month=${encodeURIComponent(month)}`,
     OVERSHARED_LICENSES: '/dashdata/overshared',
@@ -10,6 +10,14 @@ const safeLocalStorageGet = (key: string): string | null => {
   }
 };
 
+const safeLocalStorageSet = (key: string, value: string): void => {
+  try {
+    localStorage.setItem(key, value);
+  } catch (error) {
+    console.warn(`Failed to set ${key} in localStorage:`, error);
+  }
+};
+
 const safeLocalStorageRemove = (key: string): void => {
   try {
     localStorage.removeItem(key);
@@ -38,6 +46,42 @@ class ApiService {
     };
   }
 
+  // Exchanges the refresh token cookie for a new access token
+  private async refreshToken(): Promise<boolean> {
+    try {
+      const response = await fetch(buildApiUrl(API_CONFIG.ENDPOINTS.REFRESH), {
+        method: 'POST',
+        credentials: 'include',
+      });
+      if (!response.ok) {
+        return false;
+      }
+      const data = await response.json();
+      safeLocalStorageSet('auth_token', data.token);
+      return true;
+    } catch {
+      return false;
+    }
+  }
+
+  // Sends a request, refreshing the access token once if it has expired
+  private async request<T>(method: string, endpoint: string, data?: any): Promise<T> {
+    const send = () => fetch(buildApiUrl(endpoint), {
+      method,
+      headers: this.getAuthHeaders(),
+      body: data ? JSON.stringify(data) : undefined,
+    });
+
+    let response = await send();
+    if (response.status === 401) {
+      const errorData = await response.clone().json().catch(() => ({}));
+      if (errorData.code === 'TOKEN_EXPIRED' && await this.refreshToken()) {
+        response = await send();
+      }
+    }
+    return this.handleResponse<T>(response);
+  }
+
   private async handleResponse<T>(response: Response): Promise<T> {
     if (!response.ok) {
       let errorMessage = `HTTP error ${response.status}`;
@@ -75,41 +119,19 @@ class ApiService {
   }
 
   async post<T>(endpoint: string, data?: any): Promise<T> {
-    const response = await fetch(buildApiUrl(endpoint), {
-      method: 'POST',
-      headers: this.getAuthHeaders(),
-      body: data ? JSON.stringify(data) : undefined,
-    });
-
-    return this.handleResponse<T>(response);
+    return this.request<T>('POST', endpoint, data);
   }
 
   async get<T>(endpoint: string): Promise<T> {
-    const response = await fetch(buildApiUrl(endpoint), {
-      method: 'GET',
-      headers: this.getAuthHeaders(),
-    });
-
-    return this.handleResponse<T>(response);
+    return this.request<T>('GET', endpoint);
   }
 
   async put<T>(endpoint: string, data?: any): Promise<T> {
-    const response = await fetch(buildApiUrl(endpoint), {
-      method: 'PUT',
-      headers: this.getAuthHeaders(),
-      body: data ? JSON.stringify(data) : undefined,
-    });
-
-    return this.handleResponse<T>(response);
+    return this.request<T>('PUT', endpoint, data);
   }
 
   async delete<T>(endpoint: string): Promise<T> {
-    const response = await fetch(buildApiUrl(endpoint), {
-      method: 'DELETE',
-      headers: this.getAuthHeaders(),
-    });
-
-    return this.handleResponse<T>(response);
+    return this.request<T>('DELETE', endpoint);
   }
 }
 
@@ -1,6 +1,7 @@
-import { useState, useEffect } from 'react';
+import { useState, useEffect, useRef } from 'react';
 import { useNavigate, useLocation } from 'react-router-dom';
 import { useAuth } from '@/contexts/AuthContext';
+import { API_CONFIG, buildApiUrl } from '@/lib/api';
 import { Button } from '@/components/ui/button';
 import { Input } from '@/components/ui/input';
 import { Label } from '@/components/ui/label';
@@ -8,14 +9,29 @@ import { Card, CardContent, CardDescription, CardHeader, CardTitle } from '@/com
 import { Alert, AlertDescription } from '@/components/ui/alert';
 import { Loader2, AlertCircle } from 'lucide-react';
 
+// Messages of the errors reported by the server after a single sign-on
+const SSO_ERRORS: Record<string, string> = {
+  SSO_NO_ROLE: 'Your account does not belong to any group allowed to use the dashboard.',
+  SSO_ACCOUNT_CONFLICT: 'A dashboard account with the same username already exists.',
+  ACCOUNT_DISABLED: 'Your account is disabled.',
+  SSO_DENIED: 'The login was cancelled on the identity provider.',
+  SSO_UNAVAILABLE: 'The identity provider is unavailable.',
+};
+
 export default function Login() {
   const [username, setUsername] = useState('');
   const [password, setPassword] = useState('');
   const [error, setError] = useState('');
   const [isSubmitting, setIsSubmitting] = useState(false);
   const [showExpiredMessage, setShowExpiredMessage] = useState(false);
+  const [ssoEnabled, setSsoEnabled] = useState(false);
+  // set when the password is accepted and a second factor is expected
+  const [mfaToken, setMfaToken] = useState('');
+  const [code, setCode] = useState('');
+  // the refresh token rotates: the single sign-on must be completed only once
+  const ssoCompleted = useRef(false);
   
-  const { login, user } = useAuth();
+  const { login, loginSecondFactor, completeSsoLogin, user } = useAuth();
   const navigate = useNavigate();
   const location = useLocation();
 
@@ -29,13 +45,42 @@ export default function Login() {
     }
   }, [location.search, location.pathname, navigate]);
 
+  // Back from a single sign-on, complete the login or show the error
+  useEffect(() => {
+    const urlParams = new URLSearchParams(location.search);
+    const ssoError = urlParams.get('sso_error');
+    if (ssoError) {
+      setError(SSO_ERRORS[ssoError] || 'Single sign-on failed');
+      navigate(location.pathname, { replace: true, state: { fromApp: true } });
+    } else if (urlParams.get('sso') === '1' && !ssoCompleted.current) {
+      ssoCompleted.current = true;
+      completeSsoLogin().then((result) => {
+        if (result.success) {
+          navigate('/dashboard');
+        } else {
+          setError(result.error || 'Single sign-on failed');
+          navigate(location.pathname, { replace: true, state: { fromApp: true } });
+        }
+      });
+    }
+  }, [location.search, location.pathname, navigate, completeSsoLogin]);
+
+  // Show the single sign-on button if the server supports it
+  useEffect(() => {
+    fetch(buildApiUrl(API_CONFIG.ENDPOINTS.OIDC_STATUS))
+      .then((response) => response.ok ? response.json() : { enabled: false })
+      .then((data) => setSsoEnabled(!!data.enabled))
+      .catch(() => setSsoEnabled(false));
+  }, []);
+
   // If user types /login directly in URL, redirect to home
   // But allow normal navigation from the app (when coming from /)
   useEffect(() => {
     // Check if user came from a direct URL access (no referrer from within the app)
-    // Skip this check if there's an expired parameter
+    // Skip this check if there's an expired parameter or if coming back from a single sign-on
     const urlParams = new URLSearchParams(location.search);
-    if (!location.state?.fromApp && urlParams.get('expired') !== 'true') {
+    const fromSso = urlParams.has('sso') || urlParams.has('sso_error');
+    if (!location.state?.fromApp && urlParams.get('expired') !== 'true' && !fromSso) {
       navigate('/', { replace: true });
       return;
     }
@@ -63,6 +108,8 @@ export default function Login() {
     
     if (result.success) {
       navigate('/dashboard');
+    } else if (result.mfaToken) {
+      setMfaToken(result.mfaToken);
     } else {
       setError(result.error || 'Login failed');
     }
@@ -70,6 +117,27 @@ export default function Login() {
     setIsSubmitting(false);
   };
 
+  const handleCodeSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setError('');
+    setIsSubmitting(true);
+
+    const result = await loginSecondFactor(mfaToken, code.trim());
+
+    if (result.success) {
+      navigate('/dashboard');
+    } else {
+      if (result.expired) {
+        setMfaToken('');
+        setPassword('');
+      }
+      setCode('');
+      setError(result.error || 'Invalid code');
+    }
+
+    setIsSubmitting(false);
+  };
+
   return (
     <div className="min-h-screen bg-background flex items-center justify-center px-4">
       <Card className="w-full max-w-md">
@@ -80,68 +148,119 @@ export default function Login() {
           </CardDescription>
         </CardHeader>
         <CardContent>
-          <form onSubmit={handleSubmit} className="space-y-4">
-            {showExpiredMessage && (
-              <Alert variant="destructive">
-                <AlertCircle className="h-4 w-4" />
-                <AlertDescription>
-                  Your session has expired. Please log in again.
-                </AlertDescription>
-              </Alert>
-            )}
-            
-            {error && (
-              <Alert variant="destructive">
-                <AlertCircle className="h-4 w-4" />
-                <AlertDescription>{error}</AlertDescription>
-              </Alert>
-            )}
-            
-            <div className="space-y-2">
-              <Label htmlFor="username">Username</Label>
-              <Input
-                id="username"
-                name="username"
-                type="text"
-                placeholder="Enter your username"
-                value={username}
-                onChange={(e) => setUsername(e.target.value)}
-                disabled={isSubmitting}
-                autoComplete="username"
-                required
-              />
-            </div>
-            
-            <div className="space-y-2">
-              <Label htmlFor="password">Password</Label>
-              <Input
-                id="password"
-                name="password"
-                type="password"
-                placeholder="Enter your password"
-                value={password}
-                onChange={(e) => setPassword(e.target.value)}
+          {mfaToken ? (
+            <form onSubmit={handleCodeSubmit} className="space-y-4">
+              {error && (
+                <Alert variant="destructive">
+                  <AlertCircle className="h-4 w-4" />
+                  <AlertDescription>{error}</AlertDescription>
+                </Alert>
+              )}
+
+              <div className="space-y-2">
+                <Label htmlFor="code">Authentication code</Label>
+                <Input
+                  id="code"
+                  name="code"
+                  type="text"
+                  inputMode="numeric"
+                  placeholder="Code from your authenticator app, or a recovery code"
+                  value={code}
+                  onChange={(e) => setCode(e.target.value)}
+                  disabled={isSubmitting}
+                  autoComplete="one-time-code"
+                  autoFocus
+                  required
+                />
+              </div>
+
+              <Button type="submit" className="w-full" disabled={isSubmitting}>
+                {isSubmitting ? (
+                  <>
+                    <Loader2 className="mr-2 h-4 w-4 animate-spin" />
+                    Verifying...
+                  </>
+              ) : (
+                  'Verify'
+                )}
+              </Button>
+            </form>
+          ) : (
+            <form onSubmit={handleSubmit} className="space-y-4">
+              {showExpiredMessage && (
+                <Alert variant="destructive">
+                  <AlertCircle className="h-4 w-4" />
+                  <AlertDescription>
+                    Your session has expired. Please log in again.
+                  </AlertDescription>
+                </Alert>
+              )}
+              
+              {error && (
+                <Alert variant="destructive">
+                  <AlertCircle className="h-4 w-4" />
+                  <AlertDescription>{error}</AlertDescription>
+                </Alert>
+              )}
+              
+              <div className="space-y-2">
+                <Label htmlFor="username">Username</Label>
+                <Input
+                  id="username"
+                  name="username"
+                  type="text"
+                  placeholder="Enter your username"
+                  value={username}
+                  onChange={(e) => setUsername(e.target.value)}
+                  disabled={isSubmitting}
+                  autoComplete="username"
+                  required
+                />
+              </div>
+              
+              <div className="space-y-2">
+                <Label htmlFor="password">Password</Label>
+                <Input
+                  id="password"
+                  name="password"
+                  type="password"
+                  placeholder="Enter your password"
+                  value={password}
+                  onChange={(e) => setPassword(e.target.value)}
+                  disabled={isSubmitting}
+                  autoComplete="current-password"
+                  required
+                />
+              </div>
+              
+              <Button 
+                type="submit" 
+                className="w-full" 
                 disabled={isSubmitting}
-                autoComplete="current-password"
-                required
-              />
-            </div>
-            
-            <Button 
-              type="submit" 
-              className="w-full" 
-              disabled={isSubmitting}
-            >
-              {isSubmitting ? (
-                <>
-                  <Loader2 className="mr-2 h-4 w-4 animate-spin" />
-                  Signing in...
-                </>
+              >
+                {isSubmitting ? (
+                  <>
+                    <Loader2 className="mr-2 h-4 w-4 animate-spin" />
+                    Signing in...
+                  </>
               ) : (
-                'Sign In'
+                  'Sign In'
+                )}
+              </Button>
+
+              {ssoEnabled && (
+                <Button
+                  type="button"
+                  variant="outline"
+                  className="w-full"
+                  disabled={isSubmitting}
+                  onClick={() => { window.location.href = buildApiUrl(API_CONFIG.ENDPOINTS.OIDC_LOGIN); }}
+                >
+                  Sign in with your institution account
+                </Button>
               )}
-            </Button>
-          </form>
+            </form>
+          )}
         </CardContent>
       </Card>
     </div>
@@ -75,7 +75,7 @@ export default function OversharedLicenses() {
     setProcessingLicenses(prev => new Set(prev).add(licenseId));
 
     try {
-      await apiService.put(API_CONFIG.ENDPOINTS.REVOKE_LICENSE(licenseId));
+      await apiService.put(API_CONFIG.ENDPOINTS.REVOKE_LICENSE(licenseId), { reason: "overshare" });
       toast({
         title: "Success",
         description: "License revocation was successful",
@@ -0,0 +1,450 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	errAccountNotFound    = errors.New("account not found")
+	errAccountExists      = errors.New("account already exists")
+	errInvalidCredentials = errors.New("invalid credentials")
+	errAccountDisabled    = errors.New("account disabled")
+	errLastAdministrator  = errors.New("the last enabled administrator cannot be removed")
+	usernamePattern       = regexp.MustCompile(`^[A-Za-z0-9._@-]{1,64}$`)
+)
+
+const (
+	minPasswordLength = 8
+	// bcrypt ignores the bytes after 72
+	maxPasswordLength = 72
+)
+
+// Account is an operator account of the dashboard.
+// The password hash and the second factor secrets are never sent by the API.
+// Accounts created by a single sign-on have no password, and are bound to the subject of the identity provider.
+type Account struct {
+	Username     string     `json:"username"`
+	Name         string     `json:"name"`
+	Email        string     `json:"email"`
+	PasswordHash string     `json:"password_hash,omitempty"`
+	SSOSubject   string     `json:"sso_subject,omitempty"`
+	Role         string     `json:"role"`
+	Providers    []string   `json:"providers,omitempty"`
+	Disabled     bool       `json:"disabled"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastLoginAt  *time.Time `json:"last_login_at,omitempty"`
+
+	// TwoFactor tells if logins require a TOTP code, set once the TOTP secret is verified
+	TwoFactor    bool   `json:"two_factor"`
+	TOTPSecret   string `json:"totp_secret,omitempty"`
+	TOTPLastStep int64  `json:"totp_last_step,omitempty"`
+	// RecoveryCodes are the hashes of the single use codes replacing a lost TOTP device
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+// view returns a copy of the account without its password hash and second factor secrets
+func (a *Account) view() Account {
+	v := *a
+	v.PasswordHash = ""
+	v.TOTPSecret = ""
+	v.TOTPLastStep = 0
+	v.RecoveryCodes = nil
+	v.Providers = append([]string(nil), a.Providers...)
+	if a.LastLoginAt != nil {
+		at := *a.LastLoginAt
+		v.LastLoginAt = &at
+	}
+	return v
+}
+
+// accountStore holds the operator accounts, persisted as a JSON file
+type accountStore struct {
+	mu       sync.RWMutex
+	path     string
+	accounts map[string]*Account
+	// dummyHash is compared with the password of unknown users,
+	// so that the response time does not tell if an account exists
+	dummyHash []byte
+}
+
+var accounts = &accountStore{accounts: make(map[string]*Account)}
+
+// load reads the accounts file. If it does not exist, it is created
+// with an administrator account using the configured initial password.
+func (s *accountStore) load(conf AccountsConfig) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.path = conf.File
+	s.dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		password := conf.AdminPassword
+		if password == "" {
+			password = newID("admin")
+			log.Printf("👤 ADMIN_PASSWORD is not set, the password of the admin account is %s; it is not shown again", password)
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		s.accounts["admin"] = &Account{
+			Username:     "admin",
+			Name:         "admin",
+			Email:        "admin@example.com",
+			PasswordHash: string(hash),
+			Role:         RoleAdmin,
+			CreatedAt:    time.Now(),
+		}
+		log.Printf("👤 Created %s with an admin account", s.path)
+		return s.save()
+	}
+	if err != nil {
+		return err
+	}
+
+	list := []*Account{}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("invalid accounts file %s: %w", s.path, err)
+	}
+	for _, a := range list {
+		s.accounts[a.Username] = a
+	}
+	return nil
+}
+
+// save writes the accounts file, and must be called with the lock held
+func (s *accountStore) save() error {
+	list := make([]*Account, 0, len(s.accounts))
+	for _, a := range s.accounts {
+		list = append(list, a)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Username < list[j].Username })
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	// write then rename, so that a crash does not leave a truncated file
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// authenticate checks a password, and records the login on success
+func (s *accountStore) authenticate(username, password string) (Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.accounts[username]
+	if !ok {
+		bcrypt.CompareHashAndPassword(s.dummyHash, []byte(password))
+		return Account{}, errInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(a.PasswordHash), []byte(password)); err != nil {
+		return Account{}, errInvalidCredentials
+	}
+	if a.Disabled {
+		return Account{}, errAccountDisabled
+	}
+
+	now := time.Now()
+	a.LastLoginAt = &now
+	if err := s.save(); err != nil {
+		log.Printf("Error saving the accounts: %v", err)
+	}
+	return a.view(), nil
+}
+
+// get returns an account
+func (s *accountStore) get(username string) (Account, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a, ok := s.accounts[username]
+	if !ok {
+		return Account{}, false
+	}
+	return a.view(), true
+}
+
+// list returns the accounts, sorted by username
+func (s *accountStore) list() []Account {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]Account, 0, len(s.accounts))
+	for _, a := range s.accounts {
+		list = append(list, a.view())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Username < list[j].Username })
+	return list
+}
+
+// create adds an account
+func (s *accountStore) create(a *Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.accounts[a.Username]; ok {
+		return errAccountExists
+	}
+	s.accounts[a.Username] = a
+	if err := s.save(); err != nil {
+		delete(s.accounts, a.Username)
+		return err
+	}
+	return nil
+}
+
+// update applies fn to an account and saves the accounts.
+// The change is rejected if it leaves no enabled administrator.
+func (s *accountStore) update(username string, fn func(a *Account) error) (Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.accounts[username]
+	if !ok {
+		return Account{}, errAccountNotFound
+	}
+	before := *a
+	if err := fn(a); err != nil {
+		*a = before
+		return Account{}, err
+	}
+	if !s.hasAdministrator() {
+		*a = before
+		return Account{}, errLastAdministrator
+	}
+	if err := s.save(); err != nil {
+		*a = before
+		return Account{}, err
+	}
+	return a.view(), nil
+}
+
+// delete removes an account, unless it is the last enabled administrator
+func (s *accountStore) delete(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.accounts[username]
+	if !ok {
+		return errAccountNotFound
+	}
+	delete(s.accounts, username)
+	if !s.hasAdministrator() {
+		s.accounts[username] = a
+		return errLastAdministrator
+	}
+	if err := s.save(); err != nil {
+		s.accounts[username] = a
+		return err
+	}
+	return nil
+}
+
+// hasAdministrator tells if an enabled administrator remains, and must be called with the lock held
+func (s *accountStore) hasAdministrator() bool {
+	for _, a := range s.accounts {
+		if a.Role == RoleAdmin && !a.Disabled {
+			return true
+		}
+	}
+	return false
+}
+
+// hashPassword checks the length of a password and hashes it
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return "", fmt.Errorf("the password must have between %d and %d characters", minPasswordLength, maxPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// writeAccountError turns an account store error into a problem
+func writeAccountError(w http.ResponseWriter, username string, err error) {
+	switch {
+	case errors.Is(err, errAccountNotFound):
+		writeProblem(w, http.StatusNotFound, "ACCOUNT_NOT_FOUND", fmt.Sprintf("account %s not found", username))
+	case errors.Is(err, errAccountExists):
+		writeProblem(w, http.StatusConflict, "ACCOUNT_EXISTS", fmt.Sprintf("account %s already exists", username))
+	case errors.Is(err, errLastAdministrator):
+		writeProblem(w, http.StatusConflict, "LAST_ADMINISTRATOR", err.Error())
+	default:
+		log.Printf("Error updating account %s: %v", username, err)
+		writeProblem(w, http.StatusInternalServerError, "", "the accounts could not be saved")
+	}
+}
+
+// AccountRequest is the body of an account creation or update.
+// On update, omitted fields are left unchanged.
+type AccountRequest struct {
+	Username  string    `json:"username"`
+	Password  *string   `json:"password,omitempty"`
+	Name      *string   `json:"name,omitempty"`
+	Email     *string   `json:"email,omitempty"`
+	Role      *string   `json:"role,omitempty"`
+	Providers *[]string `json:"providers,omitempty"`
+	Disabled  *bool     `json:"disabled,omitempty"`
+}
+
+// apply copies the fields of the request to an account
+func (req *AccountRequest) apply(a *Account) error {
+	if req.Password != nil {
+		hash, err := hashPassword(*req.Password)
+		if err != nil {
+			return err
+		}
+		a.PasswordHash = hash
+	}
+	if req.Name != nil {
+		a.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Email != nil {
+		a.Email = strings.TrimSpace(*req.Email)
+	}
+	if req.Role != nil {
+		if !slices.Contains(roles, *req.Role) {
+			return fmt.Errorf("role must be one of %s", strings.Join(roles, ", "))
+		}
+		a.Role = *req.Role
+	}
+	if req.Providers != nil {
+		a.Providers = uniqueStrings(*req.Providers)
+	}
+	if req.Disabled != nil {
+		a.Disabled = *req.Disabled
+	}
+	return nil
+}
+
+// Accounts lists the operator accounts
+func Accounts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(accounts.list())
+}
+
+// AccountDetail returns an operator account
+func AccountDetail(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+	a, ok := accounts.get(username)
+	if !ok {
+		writeAccountError(w, username, errAccountNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a)
+}
+
+// CreateAccount creates an operator account
+func CreateAccount(w http.ResponseWriter, r *http.Request) {
+	var req AccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, "INVALID_BODY", "invalid account")
+		return
+	}
+	if !usernamePattern.MatchString(req.Username) {
+		writeProblem(w, http.StatusBadRequest, "INVALID_ACCOUNT", "the username must have 1 to 64 letters, digits or ._@- characters")
+		return
+	}
+	if req.Password == nil {
+		writeProblem(w, http.StatusBadRequest, "INVALID_ACCOUNT", "password is required")
+		return
+	}
+
+	a := &Account{
+		Username:  req.Username,
+		Name:      req.Username,
+		Role:      RoleViewer,
+		CreatedAt: time.Now(),
+	}
+	if err := req.apply(a); err != nil {
+		writeProblem(w, http.StatusBadRequest, "INVALID_ACCOUNT", err.Error())
+		return
+	}
+	if err := accounts.create(a); err != nil {
+		writeAccountError(w, req.Username, err)
+		return
+	}
+	log.Printf("👤 Account %s created by %s", a.Username, claimsFromContext(r.Context()).Username)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(a.view())
+}
+
+// UpdateAccount changes an operator account: profile, password, role, providers, enabled or disabled
+func UpdateAccount(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+
+	var req AccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, "INVALID_BODY", "invalid account")
+		return
+	}
+
+	var invalid error
+	a, err := accounts.update(username, func(a *Account) error {
+		invalid = req.apply(a)
+		return invalid
+	})
+	if invalid != nil {
+		writeProblem(w, http.StatusBadRequest, "INVALID_ACCOUNT", invalid.Error())
+		return
+	}
+	if err != nil {
+		writeAccountError(w, username, err)
+		return
+	}
+	log.Printf("👤 Account %s updated by %s", username, claimsFromContext(r.Context()).Username)
+	// a new password closes the sessions opened with the previous one, and their refresh tokens
+	if req.Password != nil {
+		count := sessions.revokeUser(username)
+		log.Printf("🔐 %d sessions of %s revoked after a password change", count, username)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a)
+}
+
+// DeleteAccount removes an operator account
+func DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+	if err := accounts.delete(username); err != nil {
+		writeAccountError(w, username, err)
+		return
+	}
+	// the sessions of the account must not outlive it, nor be inherited by an account created with the same username
+	sessions.revokeUser(username)
+	log.Printf("👤 Account %s deleted by %s", username, claimsFromContext(r.Context()).Username)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Account deleted successfully",
+	})
+}
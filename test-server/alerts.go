@@ -0,0 +1,529 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Metrics evaluated by the alert rules
+const (
+	MetricOversharedCount     = "overshared_count"
+	MetricDailyLicenses       = "daily_licenses"
+	MetricDailyLicensesChange = "daily_licenses_change_pct"
+	MetricDailyRevocations    = "daily_revocations"
+	MetricRevocationsSpike    = "revocations_spike_ratio"
+)
+
+var alertMetrics = []string{MetricOversharedCount, MetricDailyLicenses, MetricDailyLicensesChange, MetricDailyRevocations, MetricRevocationsSpike}
+
+var alertOperators = []string{">", ">=", "<", "<="}
+
+// Notification channel types
+const (
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+)
+
+// Alert states
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// alertHistorySize is the number of alert state changes kept in the history
+const alertHistorySize = 1000
+
+// AlertChannel is a destination of the alert notifications: an email address or a webhook URL
+type AlertChannel struct {
+	Type   string `json:"type"`
+	Target string `json:"target"`
+}
+
+// SilenceWindow suppresses the notifications of an alert rule between two dates
+type SilenceWindow struct {
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Comment   string    `json:"comment,omitempty"`
+	CreatedBy string    `json:"created_by"`
+}
+
+// AlertRule compares a license metric with a threshold.
+// The rule fires when the comparison is true, and is resolved when it becomes false again.
+type AlertRule struct {
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Metric    string          `json:"metric"`
+	Operator  string          `json:"operator"`
+	Threshold float64         `json:"threshold"`
+	Channels  []AlertChannel  `json:"channels"`
+	Silences  []SilenceWindow `json:"silences"`
+	CreatedAt time.Time       `json:"created_at"`
+	CreatedBy string          `json:"created_by"`
+
+	State           string     `json:"state"`
+	LastValue       float64    `json:"last_value"`
+	LastEvaluatedAt *time.Time `json:"last_evaluated_at,omitempty"`
+}
+
+// clone returns a copy of a rule sharing no memory with it, to be read after the lock is released
+func (rule *AlertRule) clone() AlertRule {
+	c := *rule
+	c.Channels = slices.Clone(rule.Channels)
+	c.Silences = slices.Clone(rule.Silences)
+	if rule.LastEvaluatedAt != nil {
+		at := *rule.LastEvaluatedAt
+		c.LastEvaluatedAt = &at
+	}
+	return c
+}
+
+// AlertEvent records a state change of an alert rule
+type AlertEvent struct {
+	RuleID    string    `json:"rule_id"`
+	RuleName  string    `json:"rule_name"`
+	State     string    `json:"state"`
+	Metric    string    `json:"metric"`
+	Value     float64   `json:"value"`
+	Operator  string    `json:"operator"`
+	Threshold float64   `json:"threshold"`
+	Timestamp time.Time `json:"timestamp"`
+	Silenced  bool      `json:"silenced"`
+	Notified  []string  `json:"notified"`
+	Errors    []string  `json:"errors,omitempty"`
+}
+
+// alertManager holds the alert rules and their history
+type alertManager struct {
+	mu      sync.Mutex
+	rules   map[string]*AlertRule
+	history []AlertEvent
+	client  *http.Client
+}
+
+var alerts = &alertManager{
+	rules:  make(map[string]*AlertRule),
+	client: &http.Client{Timeout: 10 * time.Second},
+}
+
+// licenseMetrics computes the metrics evaluated by the alert rules.
+// Daily values cover the last 24 hours, and are compared with the daily average of the previous week.
+func licenseMetrics(ctx context.Context, now time.Time) map[string]float64 {
+	dayStart := now.Add(-24 * time.Hour)
+	weekStart := dayStart.AddDate(0, 0, -7)
+
+	var overshared, licensesDay, licensesWeek, revocationsDay, revocationsWeek float64
+	for _, rec := range store.list(ctx, nil) {
+		if rec.DeviceCount >= oversharedDeviceThreshold {
+			overshared++
+		}
+		switch {
+		case rec.CreatedAt.After(dayStart):
+			licensesDay++
+		case rec.CreatedAt.After(weekStart):
+			licensesWeek++
+		}
+		for _, event := range rec.Events {
+			if event.Type != "revoke" {
+				continue
+			}
+			at, err := time.Parse(time.RFC3339, event.Timestamp)
+			if err != nil {
+				continue
+			}
+			switch {
+			case at.After(dayStart):
+				revocationsDay++
+			case at.After(weekStart):
+				revocationsWeek++
+			}
+		}
+	}
+
+	licensesChange := 0.0
+	if avg := licensesWeek / 7; avg > 0 {
+		licensesChange = (licensesDay - avg) / avg * 100
+	}
+	revocationsSpike := revocationsDay
+	if avg := revocationsWeek / 7; avg > 0 {
+		revocationsSpike = revocationsDay / avg
+	}
+
+	return map[string]float64{
+		MetricOversharedCount:     overshared,
+		MetricDailyLicenses:       licensesDay,
+		MetricDailyLicensesChange: licensesChange,
+		MetricDailyRevocations:    revocationsDay,
+		MetricRevocationsSpike:    revocationsSpike,
+	}
+}
+
+// compare applies an alert operator
+func compare(value float64, operator string, threshold float64) bool {
+	switch operator {
+	case ">":
+		return value > threshold
+	case ">=":
+		return value >= threshold
+	case "<":
+		return value < threshold
+	case "<=":
+		return value <= threshold
+	}
+	return false
+}
+
+// silenced tells if a rule is in a silence window
+func (rule *AlertRule) silenced(at time.Time) bool {
+	for _, s := range rule.Silences {
+		if !at.Before(s.Start) && at.Before(s.End) {
+			return true
+		}
+	}
+	return false
+}
+
+// run evaluates the alert rules periodically
+func (m *alertManager) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.evaluate(time.Now())
+	}
+}
+
+// evaluate computes the metrics, updates the state of every rule,
+// and notifies the channels of the rules which changed state
+func (m *alertManager) evaluate(now time.Time) {
+	metrics := licenseMetrics(context.Background(), now)
+
+	m.mu.Lock()
+	changes := []AlertEvent{}
+	channels := [][]AlertChannel{}
+	for _, rule := range m.rules {
+		value := metrics[rule.Metric]
+		state := AlertResolved
+		if compare(value, rule.Operator, rule.Threshold) {
+			state = AlertFiring
+		}
+		rule.LastValue = value
+		evaluatedAt := now
+		rule.LastEvaluatedAt = &evaluatedAt
+
+		if state == rule.State {
+			continue
+		}
+		previous := rule.State
+		rule.State = state
+		// a rule becoming resolved on its first evaluation is not worth a notification
+		if previous == "" && state == AlertResolved {
+			continue
+		}
+		changes = append(changes, AlertEvent{
+			RuleID:    rule.ID,
+			RuleName:  rule.Name,
+			State:     state,
+			Metric:    rule.Metric,
+			Value:     value,
+			Operator:  rule.Operator,
+			Threshold: rule.Threshold,
+			Timestamp: now,
+			Silenced:  rule.silenced(now),
+			Notified:  []string{},
+		})
+		channels = append(channels, append([]AlertChannel(nil), rule.Channels...))
+	}
+	m.mu.Unlock()
+
+	for i := range changes {
+		event := &changes[i]
+		log.Printf("🚨 Alert %q is %s (%s = %g)", event.RuleName, event.State, event.Metric, event.Value)
+		if event.Silenced {
+			continue
+		}
+		for _, channel := range channels[i] {
+			if err := m.notify(channel, *event); err != nil {
+				log.Printf("Alert notification to %s failed: %v", channel.Target, err)
+				event.Errors = append(event.Errors, fmt.Sprintf("%s: %v", channel.Target, err))
+				continue
+			}
+			event.Notified = append(event.Notified, channel.Target)
+		}
+	}
+
+	m.mu.Lock()
+	m.history = append(m.history, changes...)
+	if len(m.history) > alertHistorySize {
+		m.history = m.history[len(m.history)-alertHistorySize:]
+	}
+	m.mu.Unlock()
+}
+
+// notify sends an alert event to a notification channel
+func (m *alertManager) notify(channel AlertChannel, event AlertEvent) error {
+	switch channel.Type {
+	case ChannelEmail:
+		return sendAlertEmail(channel.Target, event)
+	case ChannelWebhook:
+		body, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		resp, err := m.client.Post(channel.Target, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("target returned %d", resp.StatusCode)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown channel type %q", channel.Type)
+}
+
+// sendAlertEmail sends an alert event by email, using the configured SMTP server.
+// Without SMTP server, the email is only logged.
+func sendAlertEmail(to string, event AlertEvent) error {
+	subject := fmt.Sprintf("[LCP dashboard] %s is %s", event.RuleName, event.State)
+	body := fmt.Sprintf("Alert: %s\r\nState: %s\r\nMetric: %s = %g (%s %g)\r\nTime: %s\r\n",
+		event.RuleName, event.State, event.Metric, event.Value, event.Operator, event.Threshold, event.Timestamp.Format(time.RFC3339))
+
+	if conf.SMTP.Addr == "" {
+		log.Printf("📧 (no SMTP server) To: %s - %s", to, subject)
+		return nil
+	}
+
+	// the subject is encoded, as rule names are free text
+	msg := "From: " + conf.SMTP.From + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + body
+
+	var auth smtp.Auth
+	if conf.SMTP.Username != "" {
+		host := strings.Split(conf.SMTP.Addr, ":")[0]
+		auth = smtp.PlainAuth("", conf.SMTP.Username, conf.SMTP.Password, host)
+	}
+	return smtp.SendMail(conf.SMTP.Addr, auth, conf.SMTP.From, []string{to}, []byte(msg))
+}
+
+// AlertRuleRequest is the body of an alert rule creation request
+type AlertRuleRequest struct {
+	Name      string         `json:"name"`
+	Metric    string         `json:"metric"`
+	Operator  string         `json:"operator"`
+	Threshold float64        `json:"threshold"`
+	Channels  []AlertChannel `json:"channels"`
+}
+
+func (req *AlertRuleRequest) validate() error {
+	if strings.TrimSpace(req.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	if strings.ContainsAny(req.Name, "\r\n") {
+		return fmt.Errorf("name must be a single line")
+	}
+	if !slices.Contains(alertMetrics, req.Metric) {
+		return fmt.Errorf("metric must be one of %s", strings.Join(alertMetrics, ", "))
+	}
+	if !slices.Contains(alertOperators, req.Operator) {
+		return fmt.Errorf("operator must be one of %s", strings.Join(alertOperators, ", "))
+	}
+	for _, channel := range req.Channels {
+		switch channel.Type {
+		case ChannelEmail:
+			if !strings.Contains(channel.Target, "@") || strings.ContainsAny(channel.Target, "\r\n") {
+				return fmt.Errorf("invalid email address %q", channel.Target)
+			}
+		case ChannelWebhook:
+			target, err := url.Parse(channel.Target)
+			if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+				return fmt.Errorf("invalid webhook URL %q", channel.Target)
+			}
+		default:
+			return fmt.Errorf("channel type must be %s or %s", ChannelEmail, ChannelWebhook)
+		}
+	}
+	return nil
+}
+
+// Alerts lists the alert rules and their state
+func Alerts(w http.ResponseWriter, r *http.Request) {
+	alerts.mu.Lock()
+	list := make([]AlertRule, 0, len(alerts.rules))
+	for _, rule := range alerts.rules {
+		list = append(list, rule.clone())
+	}
+	alerts.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// CreateAlert registers an alert rule
+func CreateAlert(w http.ResponseWriter, r *http.Request) {
+	var req AlertRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, "INVALID_BODY", "invalid alert rule")
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeProblem(w, http.StatusBadRequest, "INVALID_ALERT", err.Error())
+		return
+	}
+	if req.Channels == nil {
+		req.Channels = []AlertChannel{}
+	}
+
+	rule := &AlertRule{
+		ID:        newID("alert"),
+		Name:      strings.TrimSpace(req.Name),
+		Metric:    req.Metric,
+		Operator:  req.Operator,
+		Threshold: req.Threshold,
+		Channels:  req.Channels,
+		Silences:  []SilenceWindow{},
+		CreatedAt: time.Now(),
+		CreatedBy: claimsFromContext(r.Context()).Username,
+	}
+	alerts.mu.Lock()
+	alerts.rules[rule.ID] = rule
+	created := rule.clone()
+	alerts.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// DeleteAlert removes an alert rule
+func DeleteAlert(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "alertID")
+
+	alerts.mu.Lock()
+	_, ok := alerts.rules[id]
+	delete(alerts.rules, id)
+	alerts.mu.Unlock()
+
+	if !ok {
+		writeProblem(w, http.StatusNotFound, "ALERT_NOT_FOUND", fmt.Sprintf("alert %s not found", id))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Alert deleted successfully",
+	})
+}
+
+// SilenceRequest is the body of a silence request: either an explicit window,
+// or a duration starting now
+type SilenceRequest struct {
+	Start    *time.Time `json:"start,omitempty"`
+	End      *time.Time `json:"end,omitempty"`
+	Duration string     `json:"duration,omitempty"`
+	Comment  string     `json:"comment"`
+}
+
+// SilenceAlert adds a silence window to an alert rule
+func SilenceAlert(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "alertID")
+
+	var req SilenceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, "INVALID_BODY", "invalid silence")
+		return
+	}
+	silence := SilenceWindow{
+		Start:     time.Now(),
+		Comment:   strings.TrimSpace(req.Comment),
+		CreatedBy: claimsFromContext(r.Context()).Username,
+	}
+	if req.Start != nil {
+		silence.Start = *req.Start
+	}
+	switch {
+	case req.End != nil:
+		silence.End = *req.End
+	case req.Duration != "":
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, "INVALID_SILENCE", "invalid duration")
+			return
+		}
+		silence.End = silence.Start.Add(d)
+	}
+	if !silence.End.After(silence.Start) {
+		writeProblem(w, http.StatusBadRequest, "INVALID_SILENCE", "the silence must end after it starts")
+		return
+	}
+
+	alerts.mu.Lock()
+	rule, ok := alerts.rules[id]
+	if ok {
+		// drop the windows already over
+		silences := []SilenceWindow{}
+		for _, s := range rule.Silences {
+			if s.End.After(time.Now()) {
+				silences = append(silences, s)
+			}
+		}
+		rule.Silences = append(silences, silence)
+	}
+	alerts.mu.Unlock()
+
+	if !ok {
+		writeProblem(w, http.StatusNotFound, "ALERT_NOT_FOUND", fmt.Sprintf("alert %s not found", id))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(silence)
+}
+
+// AlertHistory returns the state changes of the alert rules, latest first
+func AlertHistory(w http.ResponseWriter, r *http.Request) {
+	ruleID := r.URL.Query().Get("rule_id")
+
+	alerts.mu.Lock()
+	list := []AlertEvent{}
+	for i := len(alerts.history) - 1; i >= 0; i-- {
+		if ruleID == "" || alerts.history[i].RuleID == ruleID {
+			list = append(list, alerts.history[i])
+		}
+	}
+	alerts.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// AlertMetrics returns the current value of the metrics evaluated by the alert rules
+func AlertMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(licenseMetrics(r.Context(), time.Now()))
+}
@@ -0,0 +1,254 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Publication content types
+const (
+	contentTypeEPUB = "application/epub+zip"
+	contentTypePDF  = "application/pdf+lcp"
+)
+
+// maxTopPublications is the maximum number of publications returned by the analytics endpoint
+const maxTopPublications = 100
+
+// loanDurationBuckets are the ranges, in days, of the loan duration distribution
+var loanDurationBuckets = []struct {
+	label string
+	max   float64
+}{
+	{"0-7", 7},
+	{"8-14", 14},
+	{"15-21", 21},
+	{"22-30", 30},
+	{"31-60", 60},
+	{">60", math.Inf(1)},
+}
+
+// contentType returns the content type of the publication of a license
+func (rec *licenseRecord) contentType() string {
+	if rec.ContentType == "" {
+		return contentTypeEPUB
+	}
+	return rec.ContentType
+}
+
+// loanEnd returns the date a loan was returned, or its planned end date.
+// Devices leaving a loan are also recorded as return events: only a returned license has a return date,
+// the date of its last return event.
+func (rec *licenseRecord) loanEnd() (end time.Time, returned bool) {
+	if rec.Status == StatusReturned {
+		for i := len(rec.Events) - 1; i >= 0; i-- {
+			if event := rec.Events[i]; event.Type == "return" {
+				if at, err := time.Parse(time.RFC3339, event.Timestamp); err == nil {
+					return at, true
+				}
+			}
+		}
+	}
+	end, _ = time.Parse(time.RFC3339, rec.End)
+	return end, rec.Status == StatusReturned
+}
+
+// PublicationStats are the license statistics of a publication over a period
+type PublicationStats struct {
+	PublicationID          string   `json:"publication_id"`
+	Title                  string   `json:"title"`
+	Provider               string   `json:"provider,omitempty"`
+	ContentType            string   `json:"content_type"`
+	Licenses               int      `json:"licenses"`
+	Loans                  int      `json:"loans"`
+	Purchases              int      `json:"purchases"`
+	AverageDevices         float64  `json:"average_devices"`
+	AverageLoanDays        *float64 `json:"average_loan_days"`
+	ReturnBeforeExpiryRate *float64 `json:"return_before_expiry_rate"`
+
+	devices, loanDays        float64
+	loansWithDuration, ended int
+	returnedBeforeExpiration int
+}
+
+// DurationBucket counts the loans whose duration falls in a range of days
+type DurationBucket struct {
+	Range string `json:"range"`
+	Loans int    `json:"loans"`
+}
+
+// PublicationAnalytics is the response of the publication analytics endpoint
+type PublicationAnalytics struct {
+	From                   string             `json:"from"`
+	To                     string             `json:"to"`
+	Provider               string             `json:"provider,omitempty"`
+	ContentType            string             `json:"content_type,omitempty"`
+	Licenses               int                `json:"licenses"`
+	AverageDevices         float64            `json:"average_devices"`
+	ReturnBeforeExpiryRate *float64           `json:"return_before_expiry_rate"`
+	LoanDurations          []DurationBucket   `json:"loan_durations"`
+	TopPublications        []PublicationStats `json:"top_publications"`
+}
+
+// add counts a license in the statistics of its publication
+func (s *PublicationStats) add(rec *licenseRecord, now time.Time) (loanDays float64, hasDuration bool) {
+	s.Licenses++
+	s.devices += float64(rec.DeviceCount)
+	if rec.Type != "loan" {
+		s.Purchases++
+		return 0, false
+	}
+	s.Loans++
+
+	start, err := time.Parse(time.RFC3339, rec.Start)
+	end, returned := rec.loanEnd()
+	if err == nil && !end.IsZero() {
+		loanDays = end.Sub(start).Hours() / 24
+		s.loanDays += loanDays
+		s.loansWithDuration++
+		hasDuration = true
+	}
+	if returned || rec.Status == StatusExpired || (!end.IsZero() && end.Before(now)) {
+		s.ended++
+		if returned {
+			s.returnedBeforeExpiration++
+		}
+	}
+	return loanDays, hasDuration
+}
+
+func ratio(n, total int) *float64 {
+	if total == 0 {
+		return nil
+	}
+	r := math.Round(float64(n)/float64(total)*1000) / 1000
+	return &r
+}
+
+// PublicationsAnalytics returns the top publications by number of licenses created in a period,
+// with their loan statistics.
+// Query parameters: from and to (YYYY-MM-DD, last 12 months by default), limit, provider and content_type.
+func PublicationsAnalytics(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	now := time.Now()
+
+	to := now
+	if v := query.Get("to"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, "INVALID_PERIOD", "invalid to date, expected YYYY-MM-DD")
+			return
+		}
+		to = t.AddDate(0, 0, 1)
+	}
+	from := to.AddDate(-1, 0, 0)
+	if v := query.Get("from"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, "INVALID_PERIOD", "invalid from date, expected YYYY-MM-DD")
+			return
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		writeProblem(w, http.StatusBadRequest, "INVALID_PERIOD", "from must be before to")
+		return
+	}
+	limit := 10
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxTopPublications {
+			writeProblem(w, http.StatusBadRequest, "INVALID_LIMIT", "limit must be between 1 and 100")
+			return
+		}
+		limit = n
+	}
+	provider := query.Get("provider")
+	contentType := query.Get("content_type")
+
+	records := store.list(r.Context(), func(rec *licenseRecord) bool {
+		if rec.CreatedAt.Before(from) || !rec.CreatedAt.Before(to) {
+			return false
+		}
+		if provider != "" && rec.provider() != provider {
+			return false
+		}
+		return contentType == "" || rec.contentType() == contentType
+	})
+
+	analytics := PublicationAnalytics{
+		From:            from.Format("2006-01-02"),
+		To:              to.AddDate(0, 0, -1).Format("2006-01-02"),
+		Provider:        provider,
+		ContentType:     contentType,
+		LoanDurations:   make([]DurationBucket, len(loanDurationBuckets)),
+		TopPublications: []PublicationStats{},
+	}
+	for i, bucket := range loanDurationBuckets {
+		analytics.LoanDurations[i].Range = bucket.label
+	}
+
+	stats := map[string]*PublicationStats{}
+	var total PublicationStats
+	for i := range records {
+		rec := &records[i]
+		s, ok := stats[rec.PublicationID]
+		if !ok {
+			s = &PublicationStats{
+				PublicationID: rec.PublicationID,
+				Title:         rec.PublicationTitle,
+				Provider:      rec.provider(),
+				ContentType:   rec.contentType(),
+			}
+			stats[rec.PublicationID] = s
+		}
+		days, hasDuration := s.add(rec, now)
+		total.add(rec, now)
+		if hasDuration {
+			for j, bucket := range loanDurationBuckets {
+				if math.Ceil(days) <= bucket.max {
+					analytics.LoanDurations[j].Loans++
+					break
+				}
+			}
+		}
+	}
+
+	analytics.Licenses = total.Licenses
+	if total.Licenses > 0 {
+		analytics.AverageDevices = math.Round(total.devices/float64(total.Licenses)*100) / 100
+	}
+	analytics.ReturnBeforeExpiryRate = ratio(total.returnedBeforeExpiration, total.ended)
+
+	for _, s := range stats {
+		s.AverageDevices = math.Round(s.devices/float64(s.Licenses)*100) / 100
+		if s.loansWithDuration > 0 {
+			days := math.Round(s.loanDays/float64(s.loansWithDuration)*10) / 10
+			s.AverageLoanDays = &days
+		}
+		s.ReturnBeforeExpiryRate = ratio(s.returnedBeforeExpiration, s.ended)
+		analytics.TopPublications = append(analytics.TopPublications, *s)
+	}
+	sort.Slice(analytics.TopPublications, func(i, j int) bool {
+		a, b := analytics.TopPublications[i], analytics.TopPublications[j]
+		if a.Licenses != b.Licenses {
+			return a.Licenses > b.Licenses
+		}
+		return a.PublicationID < b.PublicationID
+	})
+	if len(analytics.TopPublications) > limit {
+		analytics.TopPublications = analytics.TopPublications[:limit]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(analytics)
+}
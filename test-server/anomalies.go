@@ -0,0 +1,332 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Anomaly detection methods
+const (
+	// MethodMAD compares a day with the median of its baseline, scaled by the median absolute deviation
+	MethodMAD = "mad"
+	// MethodZScore compares a day with the mean of its baseline, scaled by the standard deviation
+	MethodZScore = "zscore"
+)
+
+const (
+	// baselineWeeks is the number of same weekdays used as the baseline of a day
+	baselineWeeks = 8
+	// defaultAnomalyThreshold is the score above which a day is unusual
+	defaultAnomalyThreshold = 3.5
+	// issuanceHistoryDays is the length of the simulated issuance history,
+	// long enough to analyze a year with a full baseline
+	issuanceHistoryDays = 365 + 7*baselineWeeks
+)
+
+// issuanceDay is the number of licenses issued for a publication on a day
+type issuanceDay struct {
+	Date             time.Time
+	Provider         string
+	PublicationID    string
+	PublicationTitle string
+	Licenses         int
+}
+
+// simulatedIssuance generates the daily license issuance history of the test server up to the day
+// before now, with weekly and yearly seasonality, and a few unusual days in the last weeks.
+// It is computed on each request, so that the history follows the current date; the number
+// of licenses of a day only depends on its date, so that past days do not change.
+func simulatedIssuance(now time.Time) []issuanceDay {
+	publications := []struct {
+		id, title, provider string
+		rate                float64
+	}{
+		{"pub-123", "The Complete Guide to Modern Web Development with React and TypeScript", "Provider A", 12},
+		{"pub-456", "Advanced JavaScript Patterns", "Provider B", 6},
+		{"pub-789", "Mastering Node.js: Build Scalable Applications", "Provider C", 9},
+		{"pub-212", "Python for Data Science and Machine Learning", "Provider D", 4},
+	}
+	// lower activity during the weekend and the summer
+	weekday := []float64{0.6, 1.1, 1.1, 1.0, 1.0, 1.2, 0.7}
+	month := []float64{1.1, 1.0, 1.0, 1.0, 0.9, 0.9, 0.7, 0.6, 1.1, 1.2, 1.2, 1.0}
+
+	today := now.UTC().Truncate(24 * time.Hour)
+	history := []issuanceDay{}
+	for d := issuanceHistoryDays; d > 0; d-- {
+		date := today.AddDate(0, 0, -d)
+		for i, pub := range publications {
+			expected := pub.rate * weekday[date.Weekday()] * month[date.Month()-1]
+			licenses := math.Max(0, math.Round(expected+dailyNoise(date, pub.id)*math.Sqrt(expected)))
+			// a promotion and an outage, so that the detector has something to find
+			switch {
+			case d == 12 && i == 2:
+				licenses *= 4
+			case d >= 5 && d <= 6 && i == 0:
+				licenses = 0
+			}
+			history = append(history, issuanceDay{
+				Date:             date,
+				Provider:         pub.provider,
+				PublicationID:    pub.id,
+				PublicationTitle: pub.title,
+				Licenses:         int(licenses),
+			})
+		}
+	}
+	return history
+}
+
+// dailyNoise returns a value of the standard normal distribution, derived from a date and a publication
+func dailyNoise(date time.Time, publicationID string) float64 {
+	sum := sha256.Sum256([]byte(publicationID + "|" + date.Format("2006-01-02")))
+	// Box-Muller transform of two uniform values, u1 in (0, 1] and u2 in [0, 1)
+	u1 := (float64(binary.BigEndian.Uint32(sum[0:4])) + 1) / (1 << 32)
+	u2 := float64(binary.BigEndian.Uint32(sum[4:8])) / (1 << 32)
+	return math.Sqrt(-2*math.Log(u1)) * math.Cos(2*math.Pi*u2)
+}
+
+// issuedLicenses calls fn for every day of the issuance history,
+// and for every license of the store as a one license day
+func issuedLicenses(ctx context.Context, fn func(day issuanceDay)) {
+	for _, day := range scoped(ctx, simulatedIssuance(time.Now()), func(day issuanceDay) string { return day.Provider }) {
+		fn(day)
+	}
+	for _, rec := range store.list(ctx, nil) {
+		fn(issuanceDay{
+			Date:             rec.CreatedAt,
+			Provider:         rec.provider(),
+			PublicationID:    rec.PublicationID,
+			PublicationTitle: rec.PublicationTitle,
+			Licenses:         1,
+		})
+	}
+}
+
+// issuanceSeries returns the daily number of licenses per group, grouped by provider or publication
+func issuanceSeries(ctx context.Context, groupBy, provider, publicationID string) (series map[string]map[time.Time]int, titles map[string]string) {
+	series = make(map[string]map[time.Time]int)
+	titles = make(map[string]string)
+
+	issuedLicenses(ctx, func(day issuanceDay) {
+		if (provider != "" && day.Provider != provider) || (publicationID != "" && day.PublicationID != publicationID) {
+			return
+		}
+		key := day.Provider
+		if groupBy == "publication" {
+			key = day.PublicationID
+			titles[key] = day.PublicationTitle
+		}
+		if series[key] == nil {
+			series[key] = make(map[time.Time]int)
+		}
+		series[key][day.Date.UTC().Truncate(24*time.Hour)] += day.Licenses
+	})
+	return series, titles
+}
+
+// Anomaly is an unusual day in the license issuance of a provider or publication
+type Anomaly struct {
+	Date             string  `json:"date"`
+	Provider         string  `json:"provider,omitempty"`
+	PublicationID    string  `json:"publication_id,omitempty"`
+	PublicationTitle string  `json:"publication_title,omitempty"`
+	Licenses         int     `json:"licenses"`
+	Expected         float64 `json:"expected"`
+	Score            float64 `json:"score"`
+	Direction        string  `json:"direction"`
+}
+
+// AnomalyReport is the response of the anomalies endpoint
+type AnomalyReport struct {
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	GroupBy   string    `json:"group_by"`
+	Method    string    `json:"method"`
+	Threshold float64   `json:"threshold"`
+	Anomalies []Anomaly `json:"anomalies"`
+}
+
+// baseline returns the expected value of a day and the scale of its usual variations,
+// computed on the same weekday of the previous weeks
+func baseline(values []float64, method string) (expected, scale float64) {
+	if method == MethodZScore {
+		for _, v := range values {
+			expected += v
+		}
+		expected /= float64(len(values))
+		for _, v := range values {
+			scale += (v - expected) * (v - expected)
+		}
+		return expected, math.Sqrt(scale / float64(len(values)))
+	}
+
+	expected = median(values)
+	deviations := make([]float64, len(values))
+	for i, v := range values {
+		deviations[i] = math.Abs(v - expected)
+	}
+	// 1.4826 makes the MAD consistent with the standard deviation of a normal distribution
+	return expected, 1.4826 * median(deviations)
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// monthFactors estimates the yearly seasonality of a series, as the ratio between the daily mean
+// of each month and the daily mean of the history ending on to. Months without licenses keep a factor of 1.
+func monthFactors(series map[time.Time]int, to time.Time) map[time.Month]float64 {
+	sums := make(map[time.Month]float64)
+	days := make(map[time.Month]int)
+	total := 0.0
+	for date := to.AddDate(0, 0, 1-issuanceHistoryDays); !date.After(to); date = date.AddDate(0, 0, 1) {
+		sums[date.Month()] += float64(series[date])
+		days[date.Month()]++
+		total += float64(series[date])
+	}
+
+	factors := make(map[time.Month]float64)
+	mean := total / issuanceHistoryDays
+	for month := time.January; month <= time.December; month++ {
+		factors[month] = 1
+		if mean > 0 && sums[month] > 0 {
+			factors[month] = sums[month] / float64(days[month]) / mean
+		}
+	}
+	return factors
+}
+
+// detectAnomalies scores every day of a series between from and to (included).
+// The baseline of a day is made of the same weekday of the previous weeks, corrected by the
+// monthly seasonality of the series, so that the start of a quieter month is not a drop.
+func detectAnomalies(series map[time.Time]int, from, to time.Time, method string, threshold float64) []Anomaly {
+	factors := monthFactors(series, to)
+	anomalies := []Anomaly{}
+	for date := from; !date.After(to); date = date.AddDate(0, 0, 1) {
+		values := make([]float64, 0, baselineWeeks)
+		for week := 1; week <= baselineWeeks; week++ {
+			day := date.AddDate(0, 0, -7*week)
+			values = append(values, float64(series[day])/factors[day.Month()])
+		}
+		expected, scale := baseline(values, method)
+		expected *= factors[date.Month()]
+		scale *= factors[date.Month()]
+		// low volume series have no variation: use the Poisson standard deviation as a floor
+		scale = math.Max(scale, math.Sqrt(math.Max(expected, 1)))
+
+		licenses := series[date]
+		score := (float64(licenses) - expected) / scale
+		if math.Abs(score) < threshold {
+			continue
+		}
+		direction := "spike"
+		if score < 0 {
+			direction = "drop"
+		}
+		anomalies = append(anomalies, Anomaly{
+			Date:      date.Format("2006-01-02"),
+			Licenses:  licenses,
+			Expected:  math.Round(expected*10) / 10,
+			Score:     math.Round(score*100) / 100,
+			Direction: direction,
+		})
+	}
+	return anomalies
+}
+
+// Anomalies marks the unusual days in the license issuance of each provider or publication.
+// Query parameters: group_by (provider or publication), days (length of the analyzed period),
+// method (mad or zscore), threshold, provider and publication_id.
+func Anomalies(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	groupBy := query.Get("group_by")
+	if groupBy == "" {
+		groupBy = "provider"
+	}
+	if groupBy != "provider" && groupBy != "publication" {
+		writeProblem(w, http.StatusBadRequest, "INVALID_GROUP_BY", "group_by must be provider or publication")
+		return
+	}
+	method := query.Get("method")
+	if method == "" {
+		method = MethodMAD
+	}
+	if method != MethodMAD && method != MethodZScore {
+		writeProblem(w, http.StatusBadRequest, "INVALID_METHOD", "method must be mad or zscore")
+		return
+	}
+	days := 30
+	if v := query.Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 365 {
+			writeProblem(w, http.StatusBadRequest, "INVALID_DAYS", "days must be between 1 and 365")
+			return
+		}
+		days = n
+	}
+	threshold := defaultAnomalyThreshold
+	if v := query.Get("threshold"); v != "" {
+		t, err := strconv.ParseFloat(v, 64)
+		if err != nil || t <= 0 {
+			writeProblem(w, http.StatusBadRequest, "INVALID_THRESHOLD", "threshold must be a positive number")
+			return
+		}
+		threshold = t
+	}
+
+	to := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -1)
+	from := to.AddDate(0, 0, 1-days)
+
+	series, titles := issuanceSeries(r.Context(), groupBy, query.Get("provider"), query.Get("publication_id"))
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	report := AnomalyReport{
+		From:      from.Format("2006-01-02"),
+		To:        to.Format("2006-01-02"),
+		GroupBy:   groupBy,
+		Method:    method,
+		Threshold: threshold,
+		Anomalies: []Anomaly{},
+	}
+	for _, key := range keys {
+		for _, anomaly := range detectAnomalies(series[key], from, to, method, threshold) {
+			if groupBy == "publication" {
+				anomaly.PublicationID = key
+				anomaly.PublicationTitle = titles[key]
+			} else {
+				anomaly.Provider = key
+			}
+			report.Anomalies = append(report.Anomalies, anomaly)
+		}
+	}
+	sort.SliceStable(report.Anomalies, func(i, j int) bool {
+		return report.Anomalies[i].Date > report.Anomalies[j].Date
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
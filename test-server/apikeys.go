@@ -0,0 +1,429 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// apiKeyPrefix starts every API key, which is formatted as "lcpk_{id}_{secret}"
+const apiKeyPrefix = "lcpk_"
+
+// API key scopes. Besides read access and event ingestion, a key can be granted the write permissions
+// of the operator roles, except the management of accounts and settings, and the export and erasure
+// of personal data, which require a PII clearance that keys do not have.
+const (
+	ScopeRead   = "read"
+	ScopeIngest = "events:write"
+)
+
+var apiKeyScopes = []string{
+	ScopeRead,
+	ScopeIngest,
+	string(PermManageLicenses),
+	string(PermManagePublications),
+}
+
+var (
+	errAPIKeyNotFound = errors.New("api key not found")
+	errAPIKeyInvalid  = errors.New("invalid api key")
+	errAPIKeyExpired  = errors.New("api key expired")
+	errAPIKeyRevoked  = errors.New("api key revoked")
+	errAPIKeyIP       = errors.New("api key not allowed from this address")
+)
+
+// APIKey is a long-lived credential of a machine client, such as a nightly export job.
+// Only the hash of the key is kept; the key itself is returned once, when it is created.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Hash       string     `json:"hash,omitempty"`
+	Scopes     []string   `json:"scopes"`
+	Providers  []string   `json:"providers,omitempty"`
+	AllowedIPs []string   `json:"allowed_ips,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	CreatedBy  string     `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	RevokedBy  string     `json:"revoked_by,omitempty"`
+}
+
+// view returns a copy of the key without its hash
+func (k *APIKey) view() APIKey {
+	v := *k
+	v.Hash = ""
+	v.Scopes = append([]string(nil), k.Scopes...)
+	v.Providers = append([]string(nil), k.Providers...)
+	v.AllowedIPs = append([]string(nil), k.AllowedIPs...)
+	return v
+}
+
+// allows tells if a key can be used from an IP address; keys without allowlist are usable from anywhere
+func (k *APIKey) allows(ip string) bool {
+	if len(k.AllowedIPs) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range k.AllowedIPs {
+		if prefix, err := netip.ParsePrefix(allowed); err == nil && prefix.Contains(addr.Unmap()) {
+			return true
+		}
+		if a, err := netip.ParseAddr(allowed); err == nil && a == addr.Unmap() {
+			return true
+		}
+	}
+	return false
+}
+
+// apiKeyStore holds the API keys, persisted as a JSON file
+type apiKeyStore struct {
+	mu   sync.Mutex
+	path string
+	keys map[string]*APIKey
+}
+
+var apiKeys = &apiKeyStore{keys: make(map[string]*APIKey)}
+
+// load reads the API keys file, if it exists
+func (s *apiKeyStore) load(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	list := []*APIKey{}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("invalid API keys file %s: %w", path, err)
+	}
+	for _, k := range list {
+		s.keys[k.ID] = k
+	}
+	return nil
+}
+
+// save writes the API keys file, and must be called with the lock held
+func (s *apiKeyStore) save() error {
+	list := make([]*APIKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		list = append(list, k)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// create generates the secret of a key, stores the key and returns the secret
+func (s *apiKeyStore) create(k *APIKey) (string, error) {
+	id := make([]byte, 6)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	k.ID = hex.EncodeToString(id)
+	token := apiKeyPrefix + k.ID + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	k.Hash = hashAPIKey(token)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[k.ID] = k
+	if err := s.save(); err != nil {
+		delete(s.keys, k.ID)
+		return "", err
+	}
+	return token, nil
+}
+
+// hashAPIKey hashes a key. The keys are random enough for a fast hash.
+func hashAPIKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// list returns the keys bound to the providers of the operator, the most recent first
+func (s *apiKeyStore) list(ctx context.Context) []APIKey {
+	t := tenantFor(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]APIKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		if t.covers(k.Providers) {
+			list = append(list, k.view())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	return list
+}
+
+// get returns a key, if bound to the providers of the operator
+func (s *apiKeyStore) get(ctx context.Context, id string) (APIKey, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, ok := s.keys[id]
+	if !ok || !tenantFor(ctx).covers(k.Providers) {
+		return APIKey{}, false
+	}
+	return k.view(), true
+}
+
+// revoke disables a key for good, if bound to the providers of the operator.
+// Revoked keys are kept, to tell who used them.
+func (s *apiKeyStore) revoke(ctx context.Context, id, by string) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, ok := s.keys[id]
+	if !ok || !tenantFor(ctx).covers(k.Providers) {
+		return APIKey{}, errAPIKeyNotFound
+	}
+	if k.RevokedAt == nil {
+		now := time.Now()
+		k.RevokedAt = &now
+		k.RevokedBy = by
+		if err := s.save(); err != nil {
+			k.RevokedAt, k.RevokedBy = nil, ""
+			return APIKey{}, err
+		}
+	}
+	return k.view(), nil
+}
+
+// authenticate checks a key presented from an IP address, and records its use
+func (s *apiKeyStore) authenticate(token, ip string) (APIKey, error) {
+	id, _, _ := strings.Cut(strings.TrimPrefix(token, apiKeyPrefix), "_")
+	hash := hashAPIKey(token)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, ok := s.keys[id]
+	if !ok || subtle.ConstantTimeCompare([]byte(hash), []byte(k.Hash)) != 1 {
+		return APIKey{}, errAPIKeyInvalid
+	}
+	now := time.Now()
+	if k.RevokedAt != nil {
+		return APIKey{}, errAPIKeyRevoked
+	}
+	if k.ExpiresAt != nil && now.After(*k.ExpiresAt) {
+		return APIKey{}, errAPIKeyExpired
+	}
+	if !k.allows(ip) {
+		return APIKey{}, errAPIKeyIP
+	}
+
+	// the last use is saved at most once a minute, unless the key is used from another address
+	save := k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) > time.Minute || k.LastUsedIP != ip
+	k.LastUsedAt = &now
+	k.LastUsedIP = ip
+	if save {
+		if err := s.save(); err != nil {
+			log.Printf("Error saving the API keys: %v", err)
+		}
+	}
+	return k.view(), nil
+}
+
+// claims returns the claims of the requests authenticated by a key.
+// A key has no role: its scopes replace the permissions of the role.
+func (k *APIKey) claims() *Claims {
+	return &Claims{
+		Username:  "apikey:" + k.ID,
+		Providers: k.Providers,
+		APIKey:    k.ID,
+		Scopes:    k.Scopes,
+	}
+}
+
+// apiKeyAuth authenticates a request bearing an API key. GET requests require the read scope,
+// the other ones are checked against the scopes by requirePermission.
+func apiKeyAuth(w http.ResponseWriter, r *http.Request, token string, next http.Handler) {
+	ip := clientIP(r)
+	key, err := apiKeys.authenticate(token, ip)
+	if err != nil {
+		log.Printf("🔑 API key refused from %s: %v", ip, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid API key", "code": "API_KEY_INVALID"})
+		return
+	}
+	if (r.Method == http.MethodGet || r.Method == http.MethodHead) && !slices.Contains(key.Scopes, ScopeRead) {
+		writeProblem(w, http.StatusForbidden, "SCOPE_DENIED", fmt.Sprintf("the API key does not have the %s scope", ScopeRead))
+		return
+	}
+
+	claims := key.claims()
+	r.Header.Set("X-Username", claims.Username)
+	ctx := context.WithValue(r.Context(), ClaimsKey, claims)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// APIKeyRequest is the body of an API key creation
+type APIKeyRequest struct {
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	Providers  []string   `json:"providers,omitempty"`
+	AllowedIPs []string   `json:"allowed_ips,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+// validate checks an API key request
+func (req *APIKeyRequest) validate() error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(req.Scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(apiKeyScopes, scope) {
+			return fmt.Errorf("scopes must be among %s", strings.Join(apiKeyScopes, ", "))
+		}
+	}
+	for _, allowed := range req.AllowedIPs {
+		if _, err := netip.ParsePrefix(allowed); err == nil {
+			continue
+		}
+		if _, err := netip.ParseAddr(allowed); err != nil {
+			return fmt.Errorf("%q is not an IP address or a CIDR range", allowed)
+		}
+	}
+	if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+		return errors.New("expires_at must be in the future")
+	}
+	return nil
+}
+
+// APIKeys lists the API keys, revoked ones included.
+// An administrator bound to some providers only sees the keys bound to them.
+func APIKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(apiKeys.list(r.Context()))
+}
+
+// APIKeyDetail returns an API key
+func APIKeyDetail(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "keyID")
+	k, ok := apiKeys.get(r.Context(), id)
+	if !ok {
+		writeProblem(w, http.StatusNotFound, "API_KEY_NOT_FOUND", fmt.Sprintf("API key %s not found", id))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(k)
+}
+
+// CreateAPIKey creates an API key. The key is only returned in this response.
+// An administrator bound to some providers can only create keys bound to them.
+func CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req APIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, "INVALID_BODY", "invalid API key")
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeProblem(w, http.StatusBadRequest, "INVALID_API_KEY", err.Error())
+		return
+	}
+	if t := tenantFor(r.Context()); t.restricted() {
+		if len(req.Providers) == 0 {
+			req.Providers = t.providers
+		}
+		for _, provider := range req.Providers {
+			if !t.allows(provider) {
+				writeProblem(w, http.StatusForbidden, "TENANT_FORBIDDEN", fmt.Sprintf("you have no access to provider %s", provider))
+				return
+			}
+		}
+	}
+
+	k := &APIKey{
+		Name:       req.Name,
+		Scopes:     uniqueStrings(req.Scopes),
+		Providers:  uniqueStrings(req.Providers),
+		AllowedIPs: req.AllowedIPs,
+		ExpiresAt:  req.ExpiresAt,
+		CreatedBy:  claimsFromContext(r.Context()).Username,
+		CreatedAt:  time.Now(),
+	}
+	token, err := apiKeys.create(k)
+	if err != nil {
+		log.Printf("Error creating an API key: %v", err)
+		writeProblem(w, http.StatusInternalServerError, "", "the API keys could not be saved")
+		return
+	}
+	log.Printf("🔑 API key %s (%s) created by %s", k.ID, k.Name, k.CreatedBy)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"key":     token,
+		"api_key": k.view(),
+	})
+}
+
+// RevokeAPIKey revokes an API key
+func RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "keyID")
+	by := claimsFromContext(r.Context()).Username
+	k, err := apiKeys.revoke(r.Context(), id, by)
+	if errors.Is(err, errAPIKeyNotFound) {
+		writeProblem(w, http.StatusNotFound, "API_KEY_NOT_FOUND", fmt.Sprintf("API key %s not found", id))
+		return
+	}
+	if err != nil {
+		log.Printf("Error revoking API key %s: %v", id, err)
+		writeProblem(w, http.StatusInternalServerError, "", "the API keys could not be saved")
+		return
+	}
+	log.Printf("🔑 API key %s (%s) revoked by %s", k.ID, k.Name, by)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(k)
+}
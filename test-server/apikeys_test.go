@@ -0,0 +1,113 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useAPIKeyStore replaces the API keys by an empty store for the duration of a test
+func useAPIKeyStore(t *testing.T) *apiKeyStore {
+	saved := apiKeys
+	t.Cleanup(func() { apiKeys = saved })
+	apiKeys = &apiKeyStore{path: filepath.Join(t.TempDir(), "apikeys.json"), keys: make(map[string]*APIKey)}
+	return apiKeys
+}
+
+func TestAPIKeyAuthenticate(t *testing.T) {
+	s := useAPIKeyStore(t)
+
+	token, err := s.create(&APIKey{Scopes: []string{ScopeRead}, AllowedIPs: []string{"192.0.2.0/24", "2001:db8::1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.authenticate(token, "192.0.2.77"); err != nil {
+		t.Errorf("key refused from an allowed network: %v", err)
+	}
+	if _, err := s.authenticate(token, "::ffff:192.0.2.77"); err != nil {
+		t.Errorf("key refused from an IPv4-mapped address: %v", err)
+	}
+	if _, err := s.authenticate(token, "198.51.100.1"); err != errAPIKeyIP {
+		t.Errorf("key from another address = %v, want %v", err, errAPIKeyIP)
+	}
+	if _, err := s.authenticate(token+"x", "192.0.2.1"); err != errAPIKeyInvalid {
+		t.Errorf("key with a wrong secret = %v, want %v", err, errAPIKeyInvalid)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	expired, _ := s.create(&APIKey{ExpiresAt: &past})
+	if _, err := s.authenticate(expired, "192.0.2.1"); err != errAPIKeyExpired {
+		t.Errorf("expired key = %v, want %v", err, errAPIKeyExpired)
+	}
+	revoked, _ := s.create(&APIKey{RevokedAt: &past})
+	if _, err := s.authenticate(revoked, "192.0.2.1"); err != errAPIKeyRevoked {
+		t.Errorf("revoked key = %v, want %v", err, errAPIKeyRevoked)
+	}
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	s := useAPIKeyStore(t)
+	reader, _ := s.create(&APIKey{Scopes: []string{ScopeRead}})
+	revoker, _ := s.create(&APIKey{Scopes: []string{string(PermManageLicenses)}})
+
+	// send sends a request authenticated by a key to a route requiring the licenses:write permission
+	send := func(method, token string) *httptest.ResponseRecorder {
+		h := authMiddleware(requirePermission(PermManageLicenses)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+		req := httptest.NewRequest(method, "/dashdata/revoke/lic-001", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send(http.MethodPut, revoker); w.Code != http.StatusOK {
+		t.Errorf("key with the scope of the route: status %d", w.Code)
+	}
+	if w := send(http.MethodPut, reader); w.Code != http.StatusForbidden || problemCode(t, w) != "SCOPE_DENIED" {
+		t.Errorf("key without the scope of the route: status %d, %s", w.Code, w.Body.String())
+	}
+	// reads require the read scope
+	if w := send(http.MethodGet, revoker); w.Code != http.StatusForbidden || problemCode(t, w) != "SCOPE_DENIED" {
+		t.Errorf("read with a key without the read scope: status %d, %s", w.Code, w.Body.String())
+	}
+	if w := send(http.MethodPut, "lcpk_000000000000_unknown"); w.Code != http.StatusUnauthorized {
+		t.Errorf("unknown key: status %d", w.Code)
+	}
+}
+
+func TestAPIKeyTenantScoping(t *testing.T) {
+	s := useAPIKeyStore(t)
+	unbound := &APIKey{Name: "unbound"}
+	boundA := &APIKey{Name: "provider A", Providers: []string{"Provider A"}}
+	boundB := &APIKey{Name: "provider B", Providers: []string{"Provider B"}}
+	for _, k := range []*APIKey{unbound, boundA, boundB} {
+		if _, err := s.create(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := contextWithClaims(&Claims{Username: "operator", Providers: []string{"Provider A"}})
+	if list := s.list(ctx); len(list) != 1 || list[0].ID != boundA.ID {
+		t.Errorf("list returned %v, want the key of provider A only", list)
+	}
+	// unbound keys give access to every provider, and are hidden like the keys of other providers
+	for _, k := range []*APIKey{unbound, boundB} {
+		if _, ok := s.get(ctx, k.ID); ok {
+			t.Errorf("get found the %s key", k.Name)
+		}
+		if _, err := s.revoke(ctx, k.ID, "operator"); err != errAPIKeyNotFound {
+			t.Errorf("revoke of the %s key = %v, want %v", k.Name, err, errAPIKeyNotFound)
+		}
+	}
+	if len(s.list(contextWithClaims(&Claims{Username: "admin"}))) != 3 {
+		t.Error("an unrestricted operator does not see every key")
+	}
+}
@@ -0,0 +1,550 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// maxAuditBody is the size of the request bodies recorded as parameters
+const maxAuditBody = 64 << 10
+
+// AuditRecord is an operator action, recorded whatever its outcome.
+// Seq numbers the records from 1, and Hash chains each record to the previous one (see auditchain.go).
+type AuditRecord struct {
+	Seq  uint64    `json:"seq"`
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+	// Operator is the username of the operator, apikey:ID for a machine client,
+	// or the username given for a login
+	Operator string `json:"operator"`
+	Action   string `json:"action"`
+	Target   string `json:"target,omitempty"`
+	// Parameters are kept as written, so that the hash of a record does not change once read back
+	Parameters json.RawMessage `json:"parameters,omitempty"`
+	// Outcome is success or failure
+	Outcome  string `json:"outcome"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	IP       string `json:"ip"`
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+// auditActions names the audited routes. The other routes are audited when they change something,
+// under the name "METHOD pattern"; reads are only audited when listed here.
+var auditActions = map[string]string{
+	"POST /dashdata/login":                                    "login",
+	"POST /dashdata/login/2fa":                                "login.second_factor",
+	"GET /dashdata/oidc/callback":                             "login.sso",
+	"POST /dashdata/logout":                                   "logout",
+	"POST /dashdata/2fa/enroll":                               "two_factor.enroll",
+	"POST /dashdata/2fa/confirm":                              "two_factor.confirm",
+	"POST /dashdata/2fa/recovery-codes":                       "two_factor.recovery_codes",
+	"POST /dashdata/2fa/disable":                              "two_factor.disable",
+	"PUT /dashdata/revoke/{licenseID}":                        "license.revoke",
+	"PUT /dashdata/reinstate/{licenseID}":                     "license.reinstate",
+	"POST /dashdata/revoke-batch":                             "license.revoke_batch",
+	"PUT /dashdata/renew/{licenseID}":                         "license.renew",
+	"PUT /dashdata/return/{licenseID}":                        "license.return",
+	"DELETE /dashdata/license-devices/{licenseID}/{deviceID}": "device.deregister",
+	"GET /dashdata/users/{userID}/export":                     "user.export",
+	"POST /dashdata/users/{userID}/erase":                     "user.erase",
+	"DELETE /dashdata/publications/{uuid}":                    "publication.delete",
+	"POST /dashdata/accounts":                                 "account.create",
+	"PUT /dashdata/accounts/{username}":                       "account.update",
+	"DELETE /dashdata/accounts/{username}":                    "account.delete",
+	"DELETE /dashdata/accounts/{username}/sessions":           "account.revoke_sessions",
+	"DELETE /dashdata/accounts/{username}/2fa":                "account.reset_two_factor",
+	"DELETE /dashdata/accounts/{username}/lockout":            "account.unlock",
+	"POST /dashdata/api-keys":                                 "api_key.create",
+	"DELETE /dashdata/api-keys/{keyID}":                       "api_key.revoke",
+	"POST /dashdata/webhooks":                                 "settings.webhook_create",
+	"DELETE /dashdata/webhooks/{webhookID}":                   "settings.webhook_delete",
+	"POST /dashdata/webhooks/deliveries/{deliveryID}/replay":  "settings.webhook_replay",
+	"POST /dashdata/alerts":                                   "settings.alert_create",
+	"DELETE /dashdata/alerts/{alertID}":                       "settings.alert_delete",
+	"POST /dashdata/alerts/{alertID}/silence":                 "settings.alert_silence",
+	"POST /dashdata/audit/checkpoints":                        "audit.checkpoint",
+}
+
+// auditSkipped are the mutating routes which are not operator actions
+var auditSkipped = map[string]bool{
+	"POST /dashdata/refresh":      true,
+	"POST /dashdata/events":       true,
+	"POST /dashdata/events/batch": true,
+}
+
+// auditLog is the append-only journal of the operator actions, a file of JSON lines.
+// The records are also kept in memory to answer the queries.
+type auditLog struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	records []AuditRecord
+
+	// checkpoints are the signed checkpoints file, and the last record it covers
+	checkpoints   string
+	checkpointSeq uint64
+}
+
+var audit = &auditLog{}
+
+// open reads the existing records of the journal, and opens it for appending
+func (l *auditLog) open(conf AuditConfig) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	path := conf.File
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64<<10), 4*maxAuditBody)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var rec AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			f.Close()
+			return fmt.Errorf("invalid audit log %s, line %d: %w", path, line, err)
+		}
+		l.records = append(l.records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return err
+	}
+	checkpoints, err := readAuditCheckpoints(conf.CheckpointFile)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	for _, cp := range checkpoints {
+		if cp.err == nil {
+			l.checkpointSeq = max(l.checkpointSeq, cp.Seq)
+		}
+	}
+	l.path = path
+	l.file = f
+	l.checkpoints = conf.CheckpointFile
+	return nil
+}
+
+// append numbers a record, chains it to the last one and writes it at the end of the journal
+func (l *auditLog) append(rec AuditRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return errors.New("the audit log is not open")
+	}
+
+	rec.Seq, rec.PrevHash = 1, ""
+	if n := len(l.records); n > 0 {
+		rec.Seq, rec.PrevHash = l.records[n-1].Seq+1, l.records[n-1].Hash
+	}
+	hash, err := rec.computeHash()
+	if err != nil {
+		return err
+	}
+	rec.Hash = hash
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	l.records = append(l.records, rec)
+	return nil
+}
+
+// auditFilter selects audit records
+type auditFilter struct {
+	operator string
+	// action matches an action or a family of actions, such as license
+	action  string
+	target  string
+	outcome string
+	ip      string
+	from    time.Time
+	to      time.Time
+}
+
+func (f auditFilter) matches(rec AuditRecord) bool {
+	return (f.operator == "" || rec.Operator == f.operator) &&
+		(f.action == "" || rec.Action == f.action || strings.HasPrefix(rec.Action, f.action+".")) &&
+		(f.target == "" || rec.Target == f.target || rec.Target == auditPrivacy.userID(f.target)) &&
+		(f.outcome == "" || rec.Outcome == f.outcome) &&
+		(f.ip == "" || rec.IP == f.ip) &&
+		(f.from.IsZero() || !rec.Time.Before(f.from)) &&
+		(f.to.IsZero() || rec.Time.Before(f.to))
+}
+
+// find returns the matching records, most recent first
+func (l *auditLog) find(f auditFilter) []AuditRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	list := []AuditRecord{}
+	for i := len(l.records) - 1; i >= 0; i-- {
+		if f.matches(l.records[i]) {
+			list = append(list, l.records[i])
+		}
+	}
+	return list
+}
+
+type auditContextKey struct{}
+
+// auditEntry collects what the handlers tell about an audited request
+type auditEntry struct {
+	mu       sync.Mutex
+	operator string
+	failure  string
+}
+
+// auditOperator sets the operator of a request made before authentication, such as a login
+func auditOperator(r *http.Request, username string) {
+	if e, ok := r.Context().Value(auditContextKey{}).(*auditEntry); ok {
+		e.mu.Lock()
+		e.operator = username
+		e.mu.Unlock()
+	}
+}
+
+// auditFailure records a request as failed, for handlers which do not answer with an error status
+func auditFailure(r *http.Request, detail string) {
+	if e, ok := r.Context().Value(auditContextKey{}).(*auditEntry); ok {
+		e.mu.Lock()
+		e.failure = detail
+		e.mu.Unlock()
+	}
+}
+
+// cappedBuffer keeps the beginning of a response, to read the error it reports
+type cappedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+// auditMiddleware records the operator actions in the audit log, once the request is answered.
+// The operator is read from the X-Username header set by the authentication middlewares;
+// the header is removed from the incoming requests so that clients cannot choose it.
+func auditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del("X-Username")
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// the body is read before the handler, as it consumes it
+		var body []byte
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Body != nil {
+			body, _ = io.ReadAll(io.LimitReader(r.Body, maxAuditBody+1))
+			r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+		}
+
+		entry := &auditEntry{}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		response := &cappedBuffer{max: 4 << 10}
+		ww.Tee(response)
+		next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), auditContextKey{}, entry)))
+
+		rctx := chi.RouteContext(r.Context())
+		if rctx == nil || rctx.RoutePattern() == "" {
+			return
+		}
+		route := r.Method + " " + rctx.RoutePattern()
+		action, listed := auditActions[route]
+		if !listed {
+			if auditSkipped[route] || r.Method == http.MethodGet || r.Method == http.MethodHead {
+				return
+			}
+			action = route
+		}
+
+		rec := AuditRecord{
+			ID:         newID("aud"),
+			Time:       time.Now().UTC(),
+			Operator:   r.Header.Get("X-Username"),
+			Action:     action,
+			Target:     auditTarget(rctx.URLParams),
+			Parameters: auditParameters(r, body),
+			Outcome:    "success",
+			Status:     ww.Status(),
+			IP:         clientIP(r),
+		}
+		if rec.Status == 0 {
+			rec.Status = http.StatusOK
+		}
+		entry.mu.Lock()
+		if entry.operator != "" {
+			rec.Operator = entry.operator
+		}
+		if entry.failure != "" {
+			rec.Outcome, rec.Detail = "failure", entry.failure
+		}
+		entry.mu.Unlock()
+		if rec.Status >= 400 {
+			rec.Outcome, rec.Detail = "failure", responseError(response.Bytes())
+		}
+
+		if err := audit.append(rec); err != nil {
+			log.Printf("🚨 Error writing the audit log (%s by %q on %q): %v", rec.Action, rec.Operator, rec.Target, err)
+		}
+	})
+}
+
+// The audit log is kept after the erasure of a user, it only holds the pseudonyms of the users
+var auditPrivacy = privacy{hide: true}
+
+// auditTarget returns the URL parameters of a request, the user identifiers being pseudonymized
+func auditTarget(params chi.RouteParams) string {
+	values := make([]string, len(params.Values))
+	for i, value := range params.Values {
+		if i < len(params.Keys) && params.Keys[i] == "userID" {
+			value = auditPrivacy.userID(value)
+		}
+		values[i] = value
+	}
+	return strings.Join(values, "/")
+}
+
+// auditParameters returns the query parameters and the JSON body of a request,
+// without the passwords, codes, tokens and secrets, and with pseudonymized user identifiers
+func auditParameters(r *http.Request, body []byte) json.RawMessage {
+	params := map[string]interface{}{}
+	for name, values := range r.URL.Query() {
+		if name == "state" || name == "code" {
+			continue
+		}
+		if len(values) == 1 {
+			params[name] = values[0]
+		} else {
+			params[name] = values
+		}
+	}
+
+	if len(body) > maxAuditBody {
+		params["body_truncated"] = true
+	} else if len(bytes.TrimSpace(body)) > 0 {
+		var decoded interface{}
+		if err := json.Unmarshal(body, &decoded); err == nil {
+			if fields, ok := decoded.(map[string]interface{}); ok {
+				for name, value := range fields {
+					params[name] = value
+				}
+			} else {
+				params["body"] = decoded
+			}
+		}
+	}
+
+	if len(params) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(redact(params))
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// redact replaces the secrets and the emails of a decoded JSON value, and pseudonymizes the user identifiers
+func redact(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for name, value := range v {
+			n := strings.ToLower(strings.ReplaceAll(name, "_", ""))
+			switch {
+			case n == "code" || strings.Contains(n, "password") || strings.Contains(n, "secret") || strings.Contains(n, "token"):
+				v[name] = "[redacted]"
+			case n == "userid" || n == "userids":
+				v[name] = pseudonymizeUsers(value)
+			case n == "useremail":
+				v[name] = "[redacted]"
+			default:
+				v[name] = redact(value)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = redact(v[i])
+		}
+	}
+	return v
+}
+
+// pseudonymizeUsers replaces a user identifier, or a list of them, by their pseudonyms
+func pseudonymizeUsers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return auditPrivacy.userID(v)
+	case []string:
+		for i := range v {
+			v[i] = auditPrivacy.userID(v[i])
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = pseudonymizeUsers(v[i])
+		}
+	}
+	return v
+}
+
+// responseError extracts the error of a failed response: the code and detail of a problem,
+// or the error of a JSON or plain text response
+func responseError(body []byte) string {
+	var p struct {
+		Code   string `json:"code"`
+		Detail string `json:"detail"`
+		Error  string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &p); err == nil {
+		switch {
+		case p.Code != "" && p.Detail != "":
+			return p.Code + ": " + p.Detail
+		case p.Code != "":
+			return p.Code
+		case p.Detail != "":
+			return p.Detail
+		default:
+			return p.Error
+		}
+	}
+	text := strings.TrimSpace(string(body))
+	if len(text) > 200 {
+		text = text[:200]
+	}
+	return text
+}
+
+// auditFilterFrom reads the filter of an audit query: operator, action, target, outcome, ip,
+// from and to, as RFC 3339 dates or YYYY-MM-DD days
+func auditFilterFrom(r *http.Request) (auditFilter, error) {
+	q := r.URL.Query()
+	f := auditFilter{
+		operator: q.Get("operator"),
+		action:   q.Get("action"),
+		target:   q.Get("target"),
+		outcome:  q.Get("outcome"),
+		ip:       q.Get("ip"),
+	}
+	for _, bound := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &f.from}, {"to", &f.to}} {
+		value := q.Get(bound.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			if t, err = time.Parse(time.DateOnly, value); err != nil {
+				return f, fmt.Errorf("invalid %s date, expected RFC 3339 or YYYY-MM-DD", bound.name)
+			}
+		}
+		*bound.t = t
+	}
+	return f, nil
+}
+
+// AuditLog lists the audit records, most recent first, one page at a time
+func AuditLog(w http.ResponseWriter, r *http.Request) {
+	f, err := auditFilterFrom(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "INVALID_FILTER", err.Error())
+		return
+	}
+	records := audit.find(f)
+
+	page := r.Context().Value(PageKey).(int)
+	perPage := r.Context().Value(PerPageKey).(int)
+	start := min((page-1)*perPage, len(records))
+	end := min(start+perPage, len(records))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"records": records[start:end],
+		"total":   len(records),
+	})
+}
+
+// ExportAuditLog downloads all the matching audit records, as CSV or JSON depending on the format parameter
+func ExportAuditLog(w http.ResponseWriter, r *http.Request) {
+	f, err := auditFilterFrom(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "INVALID_FILTER", err.Error())
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		writeProblem(w, http.StatusBadRequest, "INVALID_FORMAT", "format must be csv or json")
+		return
+	}
+	records := audit.find(f)
+	filename := fmt.Sprintf("audit-%s.%s", time.Now().UTC().Format("20060102-150405"), format)
+	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(records)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	writer := csv.NewWriter(w)
+	defer writer.Flush()
+	writer.Write([]string{"seq", "id", "time", "operator", "action", "target", "parameters", "outcome", "status", "detail", "ip", "prev_hash", "hash"})
+	for _, rec := range records {
+		writer.Write([]string{
+			strconv.FormatUint(rec.Seq, 10),
+			rec.ID,
+			rec.Time.Format(time.RFC3339),
+			rec.Operator,
+			rec.Action,
+			rec.Target,
+			string(rec.Parameters),
+			rec.Outcome,
+			strconv.Itoa(rec.Status),
+			rec.Detail,
+			rec.IP,
+			rec.PrevHash,
+			rec.Hash,
+		})
+	}
+}
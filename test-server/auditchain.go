@@ -0,0 +1,333 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// The audit log is tamper-evident: each record holds the hash of the previous one, so that a record
+// cannot be edited, removed or inserted without breaking the chain, unless all the following hashes
+// are computed again. Signed checkpoints, exported to a separate file and kept out of reach of the
+// server, pin the hash of the last record at regular intervals: a rewritten or truncated log
+// does not match them any more.
+
+// auditCheckpointSubject is the subject of the checkpoint tokens, which are not access tokens
+const auditCheckpointSubject = "audit-checkpoint"
+
+// computeHash returns the hash of a record, its JSON form without the hash itself
+func (rec AuditRecord) computeHash() (string, error) {
+	rec.Hash = ""
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// AuditCheckpoint is a line of the checkpoints file. Token is a JWS signed by an audit key,
+// verifiable with the keys of /dashdata/audit/keys, whose claims repeat the sequence number and the hash.
+type AuditCheckpoint struct {
+	Seq   uint64    `json:"seq"`
+	Hash  string    `json:"hash"`
+	Time  time.Time `json:"time"`
+	Token string    `json:"token"`
+}
+
+// auditCheckpointClaims are the claims of a checkpoint token
+type auditCheckpointClaims struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+	jwt.RegisteredClaims
+}
+
+// AuditProblem is an inconsistency found in the audit log or its checkpoints
+type AuditProblem struct {
+	// File is log or checkpoints
+	File string `json:"file"`
+	Line int    `json:"line"`
+	Seq  uint64 `json:"seq,omitempty"`
+	// Problem is unreadable, unchained, gap, chain_broken, edited, checkpoint_invalid,
+	// checkpoint_mismatch or truncated
+	Problem string `json:"problem"`
+	Detail  string `json:"detail"`
+}
+
+// AuditVerification is the result of the verification of the audit log
+type AuditVerification struct {
+	Valid   bool `json:"valid"`
+	Records int  `json:"records"`
+	// Unchained counts the records written before the hash chain, which cannot be verified
+	Unchained   int            `json:"unchained"`
+	LastSeq     uint64         `json:"last_seq"`
+	LastHash    string         `json:"last_hash"`
+	Checkpoints int            `json:"checkpoints"`
+	Problems    []AuditProblem `json:"problems"`
+}
+
+// verifyAuditLog reads an audit log and its checkpoints from the disk, and reports
+// the gaps in the numbering, the edited records, the broken links of the chain,
+// and the checkpoints which do not match the log
+func verifyAuditLog(logPath, checkpointsPath string) (AuditVerification, error) {
+	v := AuditVerification{Problems: []AuditProblem{}}
+	problem := func(file string, line int, seq uint64, code, detail string) {
+		v.Problems = append(v.Problems, AuditProblem{File: file, Line: line, Seq: seq, Problem: code, Detail: detail})
+	}
+
+	f, err := os.Open(logPath)
+	if err != nil {
+		return v, err
+	}
+	defer f.Close()
+
+	// hashes are the hashes of the records, by sequence number, to check the checkpoints
+	hashes := make(map[uint64]string)
+	// known tells if the previous record could be read, chained tells if the chain has started
+	known, chained := true, false
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64<<10), 4*maxAuditBody)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var rec AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			problem("log", line, 0, "unreadable", err.Error())
+			known = false
+			continue
+		}
+		v.Records++
+		if rec.Hash == "" {
+			if chained {
+				problem("log", line, rec.Seq, "unchained", "record without hash after the start of the chain")
+			} else {
+				v.Unchained++
+			}
+			continue
+		}
+
+		expectedSeq, expectedPrev := uint64(1), ""
+		if chained {
+			expectedSeq, expectedPrev = v.LastSeq+1, v.LastHash
+		}
+		if known && rec.Seq != expectedSeq {
+			problem("log", line, rec.Seq, "gap", fmt.Sprintf("expected record %d", expectedSeq))
+		}
+		if known && rec.PrevHash != expectedPrev {
+			problem("log", line, rec.Seq, "chain_broken", "the previous hash is not the hash of the previous record")
+		}
+		if hash, err := rec.computeHash(); err != nil || hash != rec.Hash {
+			problem("log", line, rec.Seq, "edited", "the record does not match its hash")
+		}
+		hashes[rec.Seq] = rec.Hash
+		v.LastSeq, v.LastHash = rec.Seq, rec.Hash
+		known, chained = true, true
+	}
+	if err := scanner.Err(); err != nil {
+		return v, err
+	}
+
+	checkpoints, err := readAuditCheckpoints(checkpointsPath)
+	if err != nil {
+		return v, err
+	}
+	for i, cp := range checkpoints {
+		v.Checkpoints++
+		if cp.err != nil {
+			problem("checkpoints", i+1, 0, "unreadable", cp.err.Error())
+			continue
+		}
+		claims := &auditCheckpointClaims{}
+		_, err := jwt.ParseWithClaims(cp.Token, claims, auditKeys.keyfunc,
+			jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+			jwt.WithSubject(auditCheckpointSubject))
+		switch {
+		case err != nil:
+			problem("checkpoints", i+1, cp.Seq, "checkpoint_invalid", fmt.Sprintf("invalid signature: %v", err))
+		case claims.Seq != cp.Seq || claims.Hash != cp.Hash:
+			problem("checkpoints", i+1, cp.Seq, "checkpoint_invalid", "the checkpoint does not match its signed claims")
+		case claims.Seq > v.LastSeq:
+			problem("checkpoints", i+1, claims.Seq, "truncated", fmt.Sprintf("the log ends at record %d", v.LastSeq))
+		case hashes[claims.Seq] != claims.Hash:
+			problem("checkpoints", i+1, claims.Seq, "checkpoint_mismatch", "the record is missing or its hash differs")
+		}
+	}
+
+	v.Valid = len(v.Problems) == 0
+	return v, nil
+}
+
+// auditCheckpointLine is a line of the checkpoints file, or the error reading it
+type auditCheckpointLine struct {
+	AuditCheckpoint
+	err error
+}
+
+// readAuditCheckpoints reads the checkpoints file, which may not exist yet
+func readAuditCheckpoints(path string) ([]auditCheckpointLine, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	list := []auditCheckpointLine{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var cp auditCheckpointLine
+		cp.err = json.Unmarshal(scanner.Bytes(), &cp.AuditCheckpoint)
+		list = append(list, cp)
+	}
+	return list, scanner.Err()
+}
+
+// verify checks the audit log as written on the disk
+func (l *auditLog) verify() (AuditVerification, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return verifyAuditLog(l.path, l.checkpoints)
+}
+
+// checkpoint signs the hash of the last record and appends it to the checkpoints file.
+// It returns nil when no record was added since the last checkpoint.
+func (l *auditLog) checkpoint() (*AuditCheckpoint, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.records) == 0 {
+		return nil, nil
+	}
+	last := l.records[len(l.records)-1]
+	if last.Hash == "" || last.Seq == l.checkpointSeq {
+		return nil, nil
+	}
+
+	now := time.Now().UTC()
+	token, err := auditKeys.sign(&auditCheckpointClaims{
+		Seq:  last.Seq,
+		Hash: last.Hash,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:  auditCheckpointSubject,
+			IssuedAt: jwt.NewNumericDate(now),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	cp := &AuditCheckpoint{Seq: last.Seq, Hash: last.Hash, Time: now, Token: token}
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(l.checkpoints, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return nil, err
+	}
+	l.checkpointSeq = last.Seq
+	return cp, nil
+}
+
+// checkpointEvery writes a checkpoint at each interval, when records were added
+func (l *auditLog) checkpointEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		cp, err := l.checkpoint()
+		if err != nil {
+			log.Printf("🚨 Error writing the audit checkpoint: %v", err)
+		} else if cp != nil {
+			log.Printf("🔑 Audit checkpoint at record %d", cp.Seq)
+		}
+	}
+}
+
+// VerifyAuditLog checks the hash chain of the audit log and its checkpoints
+func VerifyAuditLog(w http.ResponseWriter, r *http.Request) {
+	v, err := audit.verify()
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, "AUDIT_UNREADABLE", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// AuditCheckpoints lists the signed checkpoints, oldest first
+func AuditCheckpoints(w http.ResponseWriter, r *http.Request) {
+	audit.mu.Lock()
+	lines, err := readAuditCheckpoints(audit.checkpoints)
+	audit.mu.Unlock()
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, "AUDIT_UNREADABLE", err.Error())
+		return
+	}
+	list := []AuditCheckpoint{}
+	for _, cp := range lines {
+		if cp.err == nil {
+			list = append(list, cp.AuditCheckpoint)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// CreateAuditCheckpoint writes a checkpoint now, for instance before exporting the log
+func CreateAuditCheckpoint(w http.ResponseWriter, r *http.Request) {
+	cp, err := audit.checkpoint()
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, "CHECKPOINT_FAILED", err.Error())
+		return
+	}
+	if cp == nil {
+		writeProblem(w, http.StatusConflict, "NOTHING_TO_CHECKPOINT", "no record was added since the last checkpoint")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(cp)
+}
+
+// AuditKeys publishes the public keys verifying the checkpoints, in the JWKS format
+func AuditKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	json.NewEncoder(w).Encode(map[string][]JWK{"keys": auditKeys.jwks()})
+}
+
+// verifyAuditCommand verifies the audit log from the command line, printing the result as JSON.
+// It exits with status 1 when the log was altered.
+func verifyAuditCommand() {
+	if err := auditKeys.load(conf.Audit.Keys); err != nil {
+		log.Fatal("Error loading the audit keys:", err)
+	}
+	v, err := verifyAuditLog(conf.Audit.File, conf.Audit.CheckpointFile)
+	if err != nil {
+		log.Fatal("Error reading the audit log:", err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+	if !v.Valid {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeAuditLog writes an audit log of three records, checkpointed after the last one,
+// and returns the paths of the log and of the checkpoints
+func writeAuditLog(t *testing.T) (string, string) {
+	if err := auditKeys.load(KeysConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	l := &auditLog{}
+	if err := l.open(AuditConfig{File: filepath.Join(dir, "audit.jsonl"), CheckpointFile: filepath.Join(dir, "checkpoints.jsonl")}); err != nil {
+		t.Fatal(err)
+	}
+	defer l.file.Close()
+
+	for _, operator := range []string{"alice", "bob", "carol"} {
+		if err := l.append(AuditRecord{ID: newID("audit"), Operator: operator, Action: "revoke_license", Target: "lic-001", Outcome: "success"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := l.checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	return l.path, l.checkpoints
+}
+
+// rewriteLines replaces the lines of a file by the result of edit
+func rewriteLines(t *testing.T, path string, edit func([]string) []string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := edit(strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"))
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// auditProblems returns the problems found by the verification of an audit log
+func auditProblems(t *testing.T, logPath, checkpointsPath string) []string {
+	v, err := verifyAuditLog(logPath, checkpointsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems := []string{}
+	for _, p := range v.Problems {
+		problems = append(problems, p.Problem)
+	}
+	if v.Valid != (len(problems) == 0) {
+		t.Errorf("valid = %v with problems %v", v.Valid, problems)
+	}
+	return problems
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	logPath, checkpointsPath := writeAuditLog(t)
+	if problems := auditProblems(t, logPath, checkpointsPath); len(problems) != 0 {
+		t.Errorf("intact log: problems %v", problems)
+	}
+
+	logPath, checkpointsPath = writeAuditLog(t)
+	rewriteLines(t, logPath, func(lines []string) []string {
+		lines[1] = strings.Replace(lines[1], `"operator":"bob"`, `"operator":"mallory"`, 1)
+		return lines
+	})
+	if problems := auditProblems(t, logPath, checkpointsPath); !slices.Equal(problems, []string{"edited"}) {
+		t.Errorf("edited record: problems %v", problems)
+	}
+
+	logPath, checkpointsPath = writeAuditLog(t)
+	rewriteLines(t, logPath, func(lines []string) []string { return slices.Delete(lines, 1, 2) })
+	if problems := auditProblems(t, logPath, checkpointsPath); !slices.Equal(problems, []string{"gap", "chain_broken"}) {
+		t.Errorf("removed record: problems %v", problems)
+	}
+
+	logPath, checkpointsPath = writeAuditLog(t)
+	rewriteLines(t, logPath, func(lines []string) []string { return lines[:2] })
+	if problems := auditProblems(t, logPath, checkpointsPath); !slices.Equal(problems, []string{"truncated"}) {
+		t.Errorf("truncated log: problems %v", problems)
+	}
+}
+
+// TestVerifyAuditCheckpoints checks that a log rewritten with a consistent chain is caught by its signed checkpoint
+func TestVerifyAuditCheckpoints(t *testing.T) {
+	logPath, checkpointsPath := writeAuditLog(t)
+	rewriteLines(t, logPath, func(lines []string) []string {
+		prevHash := ""
+		for i := range lines {
+			var rec AuditRecord
+			if err := json.Unmarshal([]byte(lines[i]), &rec); err != nil {
+				t.Fatal(err)
+			}
+			rec.Operator = strings.Replace(rec.Operator, "bob", "mallory", 1)
+			rec.PrevHash = prevHash
+			hash, err := rec.computeHash()
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec.Hash, prevHash = hash, hash
+			data, _ := json.Marshal(rec)
+			lines[i] = string(data)
+		}
+		return lines
+	})
+	if problems := auditProblems(t, logPath, checkpointsPath); !slices.Equal(problems, []string{"checkpoint_mismatch"}) {
+		t.Errorf("rewritten log: problems %v", problems)
+	}
+
+	// a checkpoint signed by a key that is no longer known is refused
+	logPath, checkpointsPath = writeAuditLog(t)
+	if err := auditKeys.load(KeysConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if problems := auditProblems(t, logPath, checkpointsPath); !slices.Equal(problems, []string{"checkpoint_invalid"}) {
+		t.Errorf("checkpoint signed by an unknown key: problems %v", problems)
+	}
+}
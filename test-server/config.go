@@ -0,0 +1,304 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the test server configuration, read from environment variables.
+type Config struct {
+	LSD LSDConfig
+	// RevocationUndoWindow is the delay during which a revoked license can be reinstated
+	RevocationUndoWindow time.Duration
+	Privacy              PrivacyConfig
+	Ingest               IngestConfig
+	// SimulateActivity is the delay between two simulated license activities, 0 to disable the simulation
+	SimulateActivity time.Duration
+	Accounts         AccountsConfig
+	// APIKeysFile is the JSON file holding the API keys of the machine clients
+	APIKeysFile string
+	Tokens      TokensConfig
+	Keys        KeysConfig
+	// AlertInterval is the delay between two evaluations of the alert rules
+	AlertInterval time.Duration
+	SMTP          SMTPConfig
+	OIDC          OIDCConfig
+	TwoFactor     TwoFactorConfig
+	LoginLimits   LoginLimitsConfig
+	// TrustProxyHeaders tells if the client IP address is read from the X-Forwarded-For or X-Real-IP headers,
+	// set when the server is behind a reverse proxy
+	TrustProxyHeaders bool
+	Audit             AuditConfig
+}
+
+// AuditConfig sets the audit log of the operator actions, and its signed checkpoints
+type AuditConfig struct {
+	// File is the append-only journal of the operator actions
+	File string
+	// CheckpointFile receives the signed checkpoints, to be copied out of reach of the server
+	CheckpointFile string
+	// CheckpointInterval is the delay between two checkpoints, 0 to disable them
+	CheckpointInterval time.Duration
+	// Keys sign the checkpoints, and must be kept as long as the log
+	Keys KeysConfig
+}
+
+// LoginLimitsConfig sets the protection against password guessing: a username is locked out
+// after MaxFailures failed logins, an IP address after IPMaxFailures failed logins.
+// Failures are forgotten after Window.
+type LoginLimitsConfig struct {
+	MaxFailures   int
+	IPMaxFailures int
+	Lockout       time.Duration
+	Window        time.Duration
+}
+
+// TwoFactorConfig sets the issuer shown by authenticator apps, and the permissions
+// only granted to the operators who confirmed their login with a second factor
+type TwoFactorConfig struct {
+	Issuer      string
+	RequiredFor []Permission
+}
+
+// TokensConfig sets the lifetime of the access tokens, and of the refresh tokens
+// which renew them without a new login
+type TokensConfig struct {
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+// KeysConfig locates the keys of the access tokens: Dir holds PEM files named {kid}.pem,
+// and SigningKey is the kid of the key signing new tokens, the latest one if empty.
+type KeysConfig struct {
+	Dir        string
+	SigningKey string
+}
+
+// AccountsConfig locates the operator accounts file.
+// AdminPassword is the password of the admin account created with the file;
+// a random password is generated and logged when it is not set.
+type AccountsConfig struct {
+	File          string
+	AdminPassword string
+}
+
+// SMTPConfig describes the mail server used for alert notifications.
+// If Addr is empty, emails are only logged.
+type SMTPConfig struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+// OIDCConfig describes the OpenID Connect provider used for single sign-on.
+// Single sign-on is disabled if Issuer is empty, unless a local mock provider is started on MockAddr.
+// GroupRoles maps the groups found in the GroupsClaim of the ID token to operator roles.
+// RolePrecedence orders the roles, first wins, for the operators belonging to several groups.
+type OIDCConfig struct {
+	Issuer         string
+	ClientID       string
+	ClientSecret   string
+	RedirectURL    string
+	GroupsClaim    string
+	GroupRoles     map[string]string
+	RolePrecedence []string
+	// PostLoginURL is the dashboard page the browser is sent back to after a login
+	PostLoginURL string
+	Mock         bool
+	MockAddr     string
+}
+
+// IngestConfig holds the credentials expected from status servers pushing license events.
+// Basic authentication is refused while no password is set, API keys being accepted anyway.
+type IngestConfig struct {
+	Username string
+	Password string
+}
+
+// PrivacyConfig controls the pseudonymization of user identifiers.
+// Operators listed in ClearedUsers have access to personal data.
+// Without PseudonymKey, a key is generated and kept next to the audit log (see loadPseudonymKey).
+type PrivacyConfig struct {
+	Pseudonymize bool
+	PseudonymKey []byte
+	ClearedUsers []string
+}
+
+// LSDConfig describes how to reach the License Status Document server.
+// If BaseURL is empty, a local stand-in of the LSD server is started on StubAddr.
+type LSDConfig struct {
+	BaseURL  string
+	Username string
+	Password string
+	StubAddr string
+}
+
+func loadConfig() Config {
+	return Config{
+		LSD: LSDConfig{
+			BaseURL:  os.Getenv("LSD_BASE_URL"),
+			Username: getEnv("LSD_USERNAME", "lsd-admin"),
+			Password: getEnv("LSD_PASSWORD", "lsd-secret"),
+			StubAddr: getEnv("LSD_STUB_ADDR", "localhost:8990"),
+		},
+		RevocationUndoWindow: getEnvDuration("REVOCATION_UNDO_WINDOW", 15*time.Minute),
+		Privacy: PrivacyConfig{
+			Pseudonymize: getEnvBool("PSEUDONYMIZE_USERS", true),
+			PseudonymKey: []byte(os.Getenv("PSEUDONYM_KEY")),
+			ClearedUsers: getEnvList("PII_CLEARED_USERS", []string{"admin"}),
+		},
+		Ingest: IngestConfig{
+			Username: getEnv("INGEST_USERNAME", "lsd-server"),
+			Password: getEnv("INGEST_PASSWORD", ""),
+		},
+		SimulateActivity: getEnvDuration("SIMULATE_ACTIVITY", 0),
+		Tokens: TokensConfig{
+			AccessTTL:  getEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+			RefreshTTL: getEnvDuration("REFRESH_TOKEN_TTL", 24*time.Hour),
+		},
+		Keys: KeysConfig{
+			Dir:        os.Getenv("JWT_KEYS_DIR"),
+			SigningKey: os.Getenv("JWT_SIGNING_KEY"),
+		},
+		APIKeysFile: getEnv("API_KEYS_FILE", "apikeys.json"),
+		Audit: AuditConfig{
+			File:               getEnv("AUDIT_LOG_FILE", "audit.jsonl"),
+			CheckpointFile:     getEnv("AUDIT_CHECKPOINT_FILE", "audit-checkpoints.jsonl"),
+			CheckpointInterval: getEnvDuration("AUDIT_CHECKPOINT_INTERVAL", time.Hour),
+			Keys: KeysConfig{
+				Dir:        os.Getenv("AUDIT_KEYS_DIR"),
+				SigningKey: os.Getenv("AUDIT_SIGNING_KEY"),
+			},
+		},
+		Accounts: AccountsConfig{
+			File:          getEnv("ACCOUNTS_FILE", "accounts.json"),
+			AdminPassword: getEnv("ADMIN_PASSWORD", ""),
+		},
+		AlertInterval: getEnvDuration("ALERT_INTERVAL", time.Minute),
+		SMTP: SMTPConfig{
+			Addr:     os.Getenv("SMTP_ADDR"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     getEnv("SMTP_FROM", "lcp-dashboard@localhost"),
+		},
+		OIDC: OIDCConfig{
+			Issuer:       strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/"),
+			ClientID:     getEnv("OIDC_CLIENT_ID", "lcp-dashboard"),
+			ClientSecret: getEnv("OIDC_CLIENT_SECRET", "lcp-dashboard-secret"),
+			RedirectURL:  getEnv("OIDC_REDIRECT_URL", "http://localhost:8989/dashdata/oidc/callback"),
+			GroupsClaim:  getEnv("OIDC_GROUPS_CLAIM", "groups"),
+			GroupRoles: getEnvMap("OIDC_GROUP_ROLES", map[string]string{
+				"dashboard-admins":       RoleAdmin,
+				"dashboard-support":      RoleSupport,
+				"dashboard-publications": RolePublicationManager,
+				"dashboard-viewers":      RoleViewer,
+			}),
+			RolePrecedence: roleList(getEnvList("OIDC_ROLE_PRECEDENCE", []string{RoleAdmin, RoleSupport, RolePublicationManager, RoleViewer})),
+			PostLoginURL:   getEnv("OIDC_POST_LOGIN_URL", "http://localhost:8090/login"),
+			Mock:           getEnvBool("OIDC_MOCK", false),
+			MockAddr:       getEnv("OIDC_MOCK_ADDR", "localhost:8991"),
+		},
+		TwoFactor: TwoFactorConfig{
+			Issuer:      getEnv("TOTP_ISSUER", "LCP Dashboard"),
+			RequiredFor: permissionList(getEnvList("TWO_FACTOR_REQUIRED_FOR", nil)),
+		},
+		LoginLimits: LoginLimitsConfig{
+			MaxFailures:   getEnvInt("LOGIN_MAX_FAILURES", 5),
+			IPMaxFailures: getEnvInt("LOGIN_IP_MAX_FAILURES", 20),
+			Lockout:       getEnvDuration("LOGIN_LOCKOUT", 15*time.Minute),
+			Window:        getEnvDuration("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+		},
+		TrustProxyHeaders: getEnvBool("TRUST_PROXY_HEADERS", false),
+	}
+}
+
+// getEnv returns the value of an environment variable, or a default value if it is not set
+func getEnv(key, defaultValue string) string {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	return defaultValue
+}
+
+// getEnvDuration returns the duration held by an environment variable, or a default value
+// if it is not set or invalid
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("Invalid duration %q for %s, using %s", v, key, defaultValue)
+		return defaultValue
+	}
+	return d
+}
+
+// getEnvInt returns the positive integer held by an environment variable, or a default value
+// if it is not set or invalid
+func getEnvInt(key string, defaultValue int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		log.Printf("Invalid number %q for %s, using %d", v, key, defaultValue)
+		return defaultValue
+	}
+	return n
+}
+
+// getEnvBool returns the boolean held by an environment variable, or a default value
+// if it is not set or invalid
+func getEnvBool(key string, defaultValue bool) bool {
+	v, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return v
+}
+
+// getEnvList returns the comma separated values held by an environment variable, or a default value
+func getEnvList(key string, defaultValue []string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultValue
+	}
+	list := []string{}
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getEnvMap returns the comma separated key=value pairs held by an environment variable, or a default value
+func getEnvMap(key string, defaultValue map[string]string) map[string]string {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultValue
+	}
+	m := map[string]string{}
+	for _, item := range strings.Split(v, ",") {
+		k, value, ok := strings.Cut(item, "=")
+		if !ok {
+			log.Printf("Invalid entry %q in %s, expecting key=value", item, key)
+			continue
+		}
+		m[strings.TrimSpace(k)] = strings.TrimSpace(value)
+	}
+	return m
+}
@@ -7,11 +7,16 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -33,27 +38,32 @@ type ChartDataPoint struct {
 }
 
 type LicenseInfo struct {
-	CreatedAt        time.Time `json:"CreatedAt"`
-	UpdatedAt        time.Time `json:"UpdatedAt"`
-	UUID             string    `json:"uuid"`
-	Provider         *string   `json:"provider,omitempty"`
-	UserID           string    `json:"user_id"`
-	Start            string    `json:"start"`
-	End              string    `json:"end"`
-	MaxEnd           *string   `json:"max_end,omitempty"`
-	Copy             int       `json:"copy"`
-	Print            int       `json:"print"`
-	Status           string    `json:"status"`
-	DeviceCount      int       `json:"device_count"`
-	PublicationID    string    `json:"publication_id"`
-	PublicationTitle string    `json:"publication_title"`
+	CreatedAt        time.Time   `json:"CreatedAt"`
+	UpdatedAt        time.Time   `json:"UpdatedAt"`
+	UUID             string      `json:"uuid"`
+	Provider         *string     `json:"provider,omitempty"`
+	UserID           string      `json:"user_id"`
+	Start            string      `json:"start"`
+	End              string      `json:"end"`
+	MaxEnd           *string     `json:"max_end,omitempty"`
+	Copy             int         `json:"copy"`
+	Print            int         `json:"print"`
+	Status           string      `json:"status"`
+	DeviceCount      int         `json:"device_count"`
+	PublicationID    string      `json:"publication_id"`
+	PublicationTitle string      `json:"publication_title"`
+	Revocation       *Revocation `json:"revocation,omitempty"`
 }
 
 type Event struct {
+	ID         string `json:"id,omitempty"`
 	Timestamp  string `json:"timestamp"`
 	Type       string `json:"type"`
 	DeviceName string `json:"device_name"`
 	DeviceID   string `json:"device_id"`
+	Operator   string `json:"operator,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	Note       string `json:"note,omitempty"`
 }
 
 type DashboardData struct {
@@ -72,8 +82,22 @@ type DashboardData struct {
 	ChartData               []ChartDataPoint  `json:"chart_data"`
 }
 
+// Dashboard returns the dashboard data.
+// The provider query parameter scopes the data to a content provider;
+// the data of operators bound to providers is always computed on their providers.
 func Dashboard(w http.ResponseWriter, r *http.Request) {
 
+	if provider := r.URL.Query().Get("provider"); provider != "" || tenantFor(r.Context()).restricted() {
+		data := providerDashboard(r.Context(), provider, time.Now())
+		if data == nil {
+			writeProblem(w, http.StatusNotFound, "PROVIDER_NOT_FOUND", fmt.Sprintf("provider %s not found", provider))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(data)
+		return
+	}
+
 	data := DashboardData{
 		TotalPublications:       100,
 		TotalUsers:              50,
@@ -115,8 +139,8 @@ func Dashboard(w http.ResponseWriter, r *http.Request) {
 		},
 	}
 
-w.Header().Set("Content-Type", "application/json")
-json.NewEncoder(w).Encode(data)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
 }
 
 func ReportLicenses(w http.ResponseWriter, r *http.Request) {
@@ -143,185 +167,126 @@ func ReportLicenses(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rows := [][]string{
-		{"lic-101", "pub-123", "user-001", "active", parsedMonth.Format("2006-01-05")},
-		{"lic-102", "pub-456", "user-002", "expired", parsedMonth.AddDate(0, 0, 8).Format("2006-01-05")},
-		{"lic-103", "pub-789", "user-003", "ready", parsedMonth.AddDate(0, 0, 15).Format("2006-01-05")},
-	}
+	rows := licenseReport(r.Context(), parsedMonth)
 
+	p := privacyFor(r.Context())
 	for _, row := range rows {
+		row[2] = p.userID(row[2])
 		if err := writer.Write(row); err != nil {
 			http.Error(w, "failed to write csv row", http.StatusInternalServerError)
 			return
 		}
 	}
+
+	if reportsReady.first(month, tenantFor(r.Context())) {
+		webhooks.dispatch(WebhookReportReady, map[string]interface{}{
+			"report":       "licenses",
+			"month":        month,
+			"rows":         len(rows),
+			"requested_by": claimsFromContext(r.Context()).Username,
+		})
+	}
 }
 
-type OversharedLicenseData struct {
-ID            string `json:"id"`
-PublicationID string `json:"publication_id"`
-AltID         string `json:"alt_id"`
-Title         string `json:"title"`
-UserID        string `json:"user_id"`
-UserEmail     string `json:"user_email"`
-Type          string `json:"type"`
-Status        string `json:"status"`
-Devices       int    `json:"devices"`
+// reportLine is a line of the license report, with the provider of the license
+type reportLine struct {
+	provider string
+	row      []string
 }
 
-func OversharedLicenses(w http.ResponseWriter, r *http.Request) {
+// licenseReport returns the rows of the license report of a month visible to the operator
+func licenseReport(ctx context.Context, month time.Time) [][]string {
+	lines := scoped(ctx, []reportLine{
+		{"Provider A", []string{"lic-101", "pub-123", "user-001", "active", month.Format("2006-01-05")}},
+		{"Provider B", []string{"lic-102", "pub-456", "user-002", "expired", month.AddDate(0, 0, 8).Format("2006-01-05")}},
+		{"Provider C", []string{"lic-103", "pub-789", "user-003", "ready", month.AddDate(0, 0, 15).Format("2006-01-05")}},
+	}, func(line reportLine) string { return line.provider })
+
+	rows := [][]string{}
+	for _, line := range lines {
+		rows = append(rows, line.row)
+	}
+	return rows
+}
 
-licenses := []OversharedLicenseData{
-	{
-		ID:            "lic-001",
-		PublicationID: "pub-123",
-		AltID:         "alt-123",
-		Title:         "The Complete Guide to Modern Web Development with React and TypeScript",
-		UserID:        "user-001",
-		UserEmail:     "john.doe@example.com",
-		Type:          "loan",
-		Status:        "active",
-		Devices:       5,
-	},
-	{
-		ID:            "lic-002",
-		PublicationID: "pub-456",
-		AltID:         "alt-456",
-		Title:         "Advanced JavaScript Patterns",
-		UserID:        "user-002",
-		UserEmail:     "",
-		Type:          "buy",
-		Status:        "ready",
-		Devices:       4,
-	},
-	{
-		ID:            "lic-003",
-		PublicationID: "pub-789",
-		AltID:         "alt-789",
-		Title:         "Mastering Node.js: Build Scalable Applications",
-		UserID:        "user-003",
-		UserEmail:     "bob.wilson@example.com",
-		Type:          "loan",
-		Status:        "active",
-		Devices:       6,
-	},
-	{
-		ID:            "lic-004",
-		PublicationID: "pub-101",
-		AltID:         "alt-101",
-		Title:         "CSS Grid and Flexbox: A Complete Guide",
-		UserID:        "user-004",
-		UserEmail:     "alice.brown@example.com",
-		Type:          "buy",
-		Status:        "expired",
-		Devices:       3,
-	},
-	{
-		ID:            "lic-005",
-		PublicationID: "pub-212",
-		AltID:         "alt-212",
-		Title:         "Python for Data Science and Machine Learning",
-		UserID:        "user-005",
-		UserEmail:     "charlie.davis@example.com",
-		Type:          "loan",
-		Status:        "active",
-		Devices:       7,
-	},
+// reportsReady remembers the reports already produced, so that the report.ready webhook
+// is sent when the report of a month is first produced for a set of providers, not on each download
+var reportsReady = reportRegistry{produced: make(map[string]bool)}
+
+type reportRegistry struct {
+	mu       sync.Mutex
+	produced map[string]bool
 }
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(licenses)
+// first records the production of the report of a month for a tenant, and tells if it is the first one
+func (reg *reportRegistry) first(month string, t tenant) bool {
+	key := month + "|" + strings.Join(t.providers, ",")
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if reg.produced[key] {
+		return false
+	}
+	reg.produced[key] = true
+	return true
 }
 
-func RevokeLicense(w http.ResponseWriter, r *http.Request) {
-	licenseID := chi.URLParam(r, "licenseID")
+type OversharedLicenseData struct {
+	ID            string `json:"id"`
+	PublicationID string `json:"publication_id"`
+	AltID         string `json:"alt_id"`
+	Title         string `json:"title"`
+	UserID        string `json:"user_id"`
+	UserEmail     string `json:"user_email"`
+	Type          string `json:"type"`
+	Status        string `json:"status"`
+	Devices       int    `json:"devices"`
+}
 
-	log.Printf("🔄 Revoking license: %s", licenseID)
+func OversharedLicenses(w http.ResponseWriter, r *http.Request) {
 
-	// Simulate successful revocation
-	response := map[string]interface{}{
-		"success":   true,
-		"message":   "License revocation was successful",
-		"licenseID": licenseID,
+	records := store.list(r.Context(), func(rec *licenseRecord) bool {
+		return rec.DeviceCount >= oversharedDeviceThreshold
+	})
+	p := privacyFor(r.Context())
+
+	licenses := []OversharedLicenseData{}
+	for _, rec := range records {
+		licenses = append(licenses, OversharedLicenseData{
+			ID:            rec.UUID,
+			PublicationID: rec.PublicationID,
+			AltID:         rec.AltID,
+			Title:         rec.PublicationTitle,
+			UserID:        p.userID(rec.UserID),
+			UserEmail:     p.email(rec.UserEmail),
+			Type:          rec.Type,
+			Status:        rec.Status,
+			Devices:       rec.DeviceCount,
+		})
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(licenses)
 }
 
 func UserLicenses(w http.ResponseWriter, r *http.Request) {
-	userID := chi.URLParam(r, "userID")
-	
+	userID := resolveUserID(r.Context(), chi.URLParam(r, "userID"))
+	p := privacyFor(r.Context())
+
 	log.Printf("🔍 Searching licenses for user: %s", userID)
 
-	// Generate mock license data based on userID
-	var licenses []LicenseInfo
-	
-	// For demonstration, create different scenarios based on userID
-	switch userID {
-	case "user123":
-		licenses = []LicenseInfo{
-			{
-				CreatedAt:        time.Now().AddDate(0, -2, 0),
-				UpdatedAt:        time.Now().AddDate(0, -1, 0),
-				UUID:             "license-001-user123",
-				Provider:         stringPtr("EDRLab"),
-				UserID:           userID,
-				Start:            "2024-01-15T00:00:00Z",
-				End:              "2024-12-31T23:59:59Z",
-				MaxEnd:           stringPtr("2025-01-31T23:59:59Z"),
-				Copy:             5,
-				Print:            10,
-				Status:           "active",
-				DeviceCount:      3,
-				PublicationID:    "pub-001",
-				PublicationTitle: "Introduction to Digital Publishing",
-			},
-			{
-				CreatedAt:        time.Now().AddDate(0, -1, 0),
-				UpdatedAt:        time.Now().AddDate(0, 0, -5),
-				UUID:             "license-002-user123",
-				UserID:           userID,
-				Start:            "2024-06-01T00:00:00Z",
-				End:              "2024-11-30T23:59:59Z",
-				Copy:             3,
-				Print:            5,
-				Status:           "expired",
-				DeviceCount:      2,
-				PublicationID:    "pub-002",
-				PublicationTitle: "Advanced eBook Technologies",
-			},
-		}
-	case "john.doe":
-		licenses = []LicenseInfo{
-			{
-				CreatedAt:        time.Now().AddDate(0, -3, 0),
-				UpdatedAt:        time.Now().AddDate(0, 0, -10),
-				UUID:             "license-003-johndoe",
-				Provider:         stringPtr("LibrarySystem"),
-				UserID:           userID,
-				Start:            "2024-03-01T00:00:00Z",
-				End:              "2025-03-01T00:00:00Z",
-				Copy:             10,
-				Print:            20,
-				Status:           "active",
-				DeviceCount:      5,
-				PublicationID:    "pub-003",
-				PublicationTitle: "Modern Library Management",
-			},
-		}
-	case "empty-user":
-		// Return empty array for this user
-		licenses = []LicenseInfo{}
-	default:
-		// For unknown users, return a few generic licenses
+	licenses := []LicenseInfo{}
+	for _, rec := range store.list(r.Context(), func(rec *licenseRecord) bool { return rec.UserID == userID }) {
+		licenses = append(licenses, p.license(rec.LicenseInfo))
+	}
+
+	// For unknown users, return a generic license, except for a test user without licenses
+	if len(licenses) == 0 && userID != "empty-user" {
 		licenses = []LicenseInfo{
 			{
 				CreatedAt:        time.Now().AddDate(0, -1, -15),
 				UpdatedAt:        time.Now().AddDate(0, 0, -2),
 				UUID:             "license-default-001",
-				UserID:           userID,
+				UserID:           p.userID(userID),
 				Start:            "2024-07-01T00:00:00Z",
 				End:              "2024-12-15T23:59:59Z",
 				Copy:             2,
@@ -340,72 +305,12 @@ func UserLicenses(w http.ResponseWriter, r *http.Request) {
 
 func LicenseEvents(w http.ResponseWriter, r *http.Request) {
 	licenseID := chi.URLParam(r, "licenseID")
-	
+
 	log.Printf("Fetching events for license: %s", licenseID)
 
-	// Generate mock events based on licenseID
-	var events []Event
-	
-	// For demonstration, create different event scenarios based on licenseID
-	switch {
-	case licenseID == "license-001-user123":
-		events = []Event{
-			{
-				Timestamp:  time.Now().AddDate(0, 0, -7).Format(time.RFC3339),
-				Type:       "register",
-				DeviceName: "John's iPad",
-				DeviceID:   "device-001",
-			},
-			{
-				Timestamp:  time.Now().AddDate(0, 0, -5).Format(time.RFC3339),
-				Type:       "return",
-				DeviceName: "John's iPad",
-				DeviceID:   "device-001",
-			},
-			{
-				Timestamp:  time.Now().AddDate(0, 0, -3).Format(time.RFC3339),
-				Type:       "register",
-				DeviceName: "John's iPhone",
-				DeviceID:   "device-002",
-			},
-		}
-	case licenseID == "license-002-user123":
-		events = []Event{
-			{
-				Timestamp:  time.Now().AddDate(0, 0, -10).Format(time.RFC3339),
-				Type:       "register",
-				DeviceName: "MacBook Pro",
-				DeviceID:   "device-003",
-			},
-			{
-				Timestamp:  time.Now().AddDate(0, 0, -1).Format(time.RFC3339),
-				Type:       "renew",
-				DeviceName: "MacBook Pro",
-				DeviceID:   "device-003",
-			},
-		}
-	case licenseID == "license-003-johndoe":
-		events = []Event{
-			{
-				Timestamp:  time.Now().AddDate(0, 0, -20).Format(time.RFC3339),
-				Type:       "register",
-				DeviceName: "Library Tablet 1",
-				DeviceID:   "lib-tablet-001",
-			},
-			{
-				Timestamp:  time.Now().AddDate(0, 0, -15).Format(time.RFC3339),
-				Type:       "register",
-				DeviceName: "Library Tablet 2", 
-				DeviceID:   "lib-tablet-002",
-			},
-			{
-				Timestamp:  time.Now().AddDate(0, 0, -10).Format(time.RFC3339),
-				Type:       "return",
-				DeviceName: "Library Tablet 1",
-				DeviceID:   "lib-tablet-001",
-			},
-		}
-	default:
+	rec, ok := store.get(r.Context(), licenseID)
+	events := rec.Events
+	if !ok {
 		// For unknown licenses, return basic events
 		events = []Event{
 			{
@@ -416,12 +321,24 @@ func LicenseEvents(w http.ResponseWriter, r *http.Request) {
 			},
 		}
 	}
+	if events == nil {
+		events = []Event{}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(events)
+	json.NewEncoder(w).Encode(privacyFor(r.Context()).events(events))
 }
 
 // Helper function to create string pointers
 func stringPtr(s string) *string {
 	return &s
 }
+
+// Helper function to create random identifiers, e.g. "wh-3f2a9c0d1e4b5a69"
+func newID(prefix string) string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return prefix + "-" + hex.EncodeToString(b)
+}
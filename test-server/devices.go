@@ -0,0 +1,144 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Device is a reading device registered on a license
+type Device struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// seedDevices builds the devices of a seeded license from its events,
+// completed by generic devices up to the device count of the license.
+func (rec *licenseRecord) seedDevices() {
+	for _, event := range rec.Events {
+		if event.DeviceID == "" {
+			continue
+		}
+		at, err := time.Parse(time.RFC3339, event.Timestamp)
+		if err != nil {
+			continue
+		}
+		if device := rec.device(event.DeviceID); device != nil {
+			device.LastSeen = at
+			continue
+		}
+		rec.Devices = append(rec.Devices, Device{ID: event.DeviceID, Name: event.DeviceName, FirstSeen: at, LastSeen: at})
+	}
+	for i := len(rec.Devices); i < rec.DeviceCount; i++ {
+		firstSeen := rec.CreatedAt.AddDate(0, 0, i).Truncate(time.Second)
+		rec.Devices = append(rec.Devices, Device{
+			ID:        fmt.Sprintf("%s-device-%d", rec.UUID, i+1),
+			Name:      fmt.Sprintf("Reading device %d", i+1),
+			FirstSeen: firstSeen,
+			LastSeen:  firstSeen.AddDate(0, 0, 1),
+		})
+	}
+}
+
+// device returns a device registered on the license, or nil
+func (rec *licenseRecord) device(deviceID string) *Device {
+	for i := range rec.Devices {
+		if rec.Devices[i].ID == deviceID {
+			return &rec.Devices[i]
+		}
+	}
+	return nil
+}
+
+// LicenseDevices lists the devices registered on a license
+func LicenseDevices(w http.ResponseWriter, r *http.Request) {
+	licenseID := chi.URLParam(r, "licenseID")
+
+	rec, ok := store.get(r.Context(), licenseID)
+	if !ok {
+		writeProblem(w, http.StatusNotFound, "LICENSE_NOT_FOUND", fmt.Sprintf("license %s not found", licenseID))
+		return
+	}
+	devices := rec.Devices
+	if devices == nil {
+		devices = []Device{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(privacyFor(r.Context()).devices(devices))
+}
+
+// DeregisterDevice removes a device from a license, freeing a slot for another device
+func DeregisterDevice(w http.ResponseWriter, r *http.Request) {
+	licenseID := chi.URLParam(r, "licenseID")
+	deviceID := chi.URLParam(r, "deviceID")
+	operator := claimsFromContext(r.Context()).Username
+
+	rec, ok := store.get(r.Context(), licenseID)
+	if !ok {
+		writeProblem(w, http.StatusNotFound, "LICENSE_NOT_FOUND", fmt.Sprintf("license %s not found", licenseID))
+		return
+	}
+	if rec.device(deviceID) == nil {
+		writeProblem(w, http.StatusNotFound, "DEVICE_NOT_FOUND", fmt.Sprintf("device %s is not registered on license %s", deviceID, licenseID))
+		return
+	}
+
+	log.Printf("📵 Deregistering device %s from license %s", deviceID, licenseID)
+
+	if err := lsd.DeregisterDevice(r.Context(), licenseID, deviceID); err != nil {
+		log.Printf("LSD deregistration of %s on %s failed: %v", deviceID, licenseID, err)
+		writeProblem(w, http.StatusBadGateway, "LSD_ERROR", "the license status server refused the deregistration: "+err.Error())
+		return
+	}
+
+	err := store.update(r.Context(), licenseID, func(rec *licenseRecord) error {
+		device := rec.device(deviceID)
+		if device == nil {
+			return fmt.Errorf("device %s is not registered on license %s", deviceID, licenseID)
+		}
+		rec.Events = append(rec.Events, Event{
+			Timestamp:  time.Now().Format(time.RFC3339),
+			Type:       "deregister",
+			DeviceName: device.Name,
+			DeviceID:   device.ID,
+			Operator:   operator,
+		})
+		for i := range rec.Devices {
+			if rec.Devices[i].ID == deviceID {
+				rec.Devices = append(rec.Devices[:i], rec.Devices[i+1:]...)
+				break
+			}
+		}
+		if rec.DeviceCount > 0 {
+			rec.DeviceCount--
+		}
+		return nil
+	})
+	if err != nil {
+		writeProblem(w, http.StatusConflict, "DEVICE_NOT_FOUND", err.Error())
+		return
+	}
+
+	license, _ := store.get(r.Context(), licenseID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":      true,
+		"message":      "Device deregistration was successful",
+		"licenseID":    licenseID,
+		"deviceID":     deviceID,
+		"device_count": license.DeviceCount,
+	})
+}
@@ -0,0 +1,291 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxIngestBatchSize limits the number of events of a batch
+const maxIngestBatchSize = 1000
+
+// IngestedEvent is a license event pushed by a License Status Document server.
+// ID is optional; if missing, events are deduplicated on their content.
+type IngestedEvent struct {
+	ID         string     `json:"id,omitempty"`
+	LicenseID  string     `json:"license_id"`
+	Type       string     `json:"type"`
+	Timestamp  time.Time  `json:"timestamp"`
+	DeviceID   string     `json:"device_id,omitempty"`
+	DeviceName string     `json:"device_name,omitempty"`
+	End        *time.Time `json:"end,omitempty"`
+}
+
+// IngestResult is the outcome of the ingestion of an event
+type IngestResult struct {
+	ID     string   `json:"id"`
+	Result string   `json:"result"`
+	Error  *Problem `json:"error,omitempty"`
+}
+
+// Ingestion results
+const (
+	ingestAccepted  = "accepted"
+	ingestDuplicate = "duplicate"
+	ingestRejected  = "rejected"
+)
+
+// eventTransitions lists, per event type, the license statuses in which the event is valid
+// and the status the license moves to ("" keeps the current status)
+var eventTransitions = map[string]struct {
+	from []string
+	to   string
+}{
+	"register": {from: []string{StatusReady, StatusActive}, to: StatusActive},
+	"renew":    {from: []string{StatusActive}},
+	"return":   {from: []string{StatusActive}, to: StatusReturned},
+	"revoke":   {from: []string{StatusReady, StatusActive}, to: StatusRevoked},
+	"cancel":   {from: []string{StatusReady}, to: StatusCancelled},
+	"expire":   {from: []string{StatusReady, StatusActive}, to: StatusExpired},
+}
+
+// Ingested events are deduplicated on a limited window, as status servers only retry recent events
+const (
+	ingestDedupWindow = 24 * time.Hour
+	maxIngestSeen     = 100000
+)
+
+// ingestion serializes event ingestion and remembers the identifiers of the recently ingested events,
+// in the order of their ingestion
+var ingestion = struct {
+	mu    sync.Mutex
+	seen  map[string]time.Time
+	order []ingestedID
+}{seen: make(map[string]time.Time)}
+
+// ingestedID is an event identifier with its ingestion time
+type ingestedID struct {
+	id string
+	at time.Time
+}
+
+// rememberIngested records an ingested event and forgets the events out of the deduplication window.
+// It must be called with the ingestion lock held.
+func rememberIngested(id string, now time.Time) {
+	ingestion.seen[id] = now
+	ingestion.order = append(ingestion.order, ingestedID{id: id, at: now})
+	for len(ingestion.order) > 0 {
+		oldest := ingestion.order[0]
+		if len(ingestion.order) <= maxIngestSeen && now.Sub(oldest.at) < ingestDedupWindow {
+			break
+		}
+		// an event ingested again after the window is remembered from its last ingestion
+		if ingestion.seen[oldest.id].Equal(oldest.at) {
+			delete(ingestion.seen, oldest.id)
+		}
+		ingestion.order = ingestion.order[1:]
+	}
+}
+
+// ingestAuth checks the credentials of the status server pushing events
+func ingestAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// API keys with the ingestion scope are accepted besides the configured credentials
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && strings.HasPrefix(token, apiKeyPrefix) {
+			key, err := apiKeys.authenticate(token, clientIP(r))
+			if err != nil || !slices.Contains(key.Scopes, ScopeIngest) {
+				writeProblem(w, http.StatusUnauthorized, "INVALID_CREDENTIALS", "invalid API key, or without the events:write scope")
+				return
+			}
+			// the events are then limited to the providers of the key
+			claims := key.claims()
+			r.Header.Set("X-Username", claims.Username)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ClaimsKey, claims)))
+			return
+		}
+
+		username, password, ok := r.BasicAuth()
+		if !ok || conf.Ingest.Password == "" ||
+			subtle.ConstantTimeCompare([]byte(username), []byte(conf.Ingest.Username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(conf.Ingest.Password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="ingest"`)
+			writeProblem(w, http.StatusUnauthorized, "INVALID_CREDENTIALS", "invalid ingestion credentials")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// eventID returns the identifier of an event, computed from its content if not provided
+func (e *IngestedEvent) eventID() string {
+	if e.ID != "" {
+		return e.ID
+	}
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%s|%s", e.LicenseID, e.Type, e.Timestamp.UTC().Format(time.RFC3339Nano), e.DeviceID)))
+	return "evt-" + hex.EncodeToString(h[:])[:24]
+}
+
+// validate checks the syntax of an event
+func (e *IngestedEvent) validate() *Problem {
+	if e.LicenseID == "" {
+		return newProblem(http.StatusBadRequest, "INVALID_EVENT", "license_id is required")
+	}
+	if _, ok := eventTransitions[e.Type]; !ok {
+		return newProblem(http.StatusBadRequest, "INVALID_EVENT", fmt.Sprintf("unknown event type %q", e.Type))
+	}
+	if e.Timestamp.IsZero() {
+		return newProblem(http.StatusBadRequest, "INVALID_EVENT", "timestamp is required")
+	}
+	if e.Timestamp.After(time.Now().Add(5 * time.Minute)) {
+		return newProblem(http.StatusBadRequest, "INVALID_EVENT", "timestamp is in the future")
+	}
+	if e.Type == "register" && e.DeviceID == "" {
+		return newProblem(http.StatusBadRequest, "INVALID_EVENT", "device_id is required for a register event")
+	}
+	return nil
+}
+
+// ingest validates, deduplicates and applies an event to the license store,
+// within the providers of the API key held by the context, if any
+func ingest(ctx context.Context, e IngestedEvent) IngestResult {
+	id := e.eventID()
+	result := IngestResult{ID: id, Result: ingestRejected}
+
+	if problem := e.validate(); problem != nil {
+		result.Error = problem
+		return result
+	}
+
+	ingestion.mu.Lock()
+	defer ingestion.mu.Unlock()
+
+	if seenAt, ok := ingestion.seen[id]; ok && time.Since(seenAt) < ingestDedupWindow {
+		result.Result = ingestDuplicate
+		return result
+	}
+
+	err := store.update(ctx, e.LicenseID, func(rec *licenseRecord) error {
+		transition := eventTransitions[e.Type]
+		allowed := false
+		for _, status := range transition.from {
+			if rec.Status == status {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("a %s event is not valid for a %s license", e.Type, rec.Status)
+		}
+		if transition.to != "" {
+			rec.Status = transition.to
+		}
+
+		switch e.Type {
+		case "register":
+			if device := rec.device(e.DeviceID); device != nil {
+				device.LastSeen = e.Timestamp
+			} else {
+				rec.Devices = append(rec.Devices, Device{ID: e.DeviceID, Name: e.DeviceName, FirstSeen: e.Timestamp, LastSeen: e.Timestamp})
+				rec.DeviceCount++
+			}
+		case "renew":
+			if e.End != nil {
+				rec.End = e.End.UTC().Format(time.RFC3339)
+			}
+		}
+		if device := rec.device(e.DeviceID); device != nil && e.Timestamp.After(device.LastSeen) {
+			device.LastSeen = e.Timestamp
+		}
+
+		rec.Events = append(rec.Events, Event{
+			ID:         id,
+			Timestamp:  e.Timestamp.Format(time.RFC3339),
+			Type:       e.Type,
+			DeviceName: e.DeviceName,
+			DeviceID:   e.DeviceID,
+		})
+		return nil
+	})
+	if err == errLicenseNotFound {
+		result.Error = newProblem(http.StatusNotFound, "LICENSE_NOT_FOUND", fmt.Sprintf("license %s not found", e.LicenseID))
+		return result
+	}
+	if err != nil {
+		result.Error = newProblem(http.StatusConflict, "INVALID_TRANSITION", err.Error())
+		return result
+	}
+
+	rememberIngested(id, time.Now())
+	result.Result = ingestAccepted
+	return result
+}
+
+// IngestEvent receives a single license event from a status server
+func IngestEvent(w http.ResponseWriter, r *http.Request) {
+	var event IngestedEvent
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		writeProblem(w, http.StatusBadRequest, "INVALID_BODY", "invalid event")
+		return
+	}
+
+	result := ingest(r.Context(), event)
+	if result.Error != nil {
+		result.Error.write(w)
+		return
+	}
+	log.Printf("📨 Event %s (%s) on license %s: %s", result.ID, event.Type, event.LicenseID, result.Result)
+
+	w.Header().Set("Content-Type", "application/json")
+	if result.Result == ingestAccepted {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// IngestEvents receives a batch of license events from a status server.
+// Events are applied in order, and a result is returned for each of them.
+func IngestEvents(w http.ResponseWriter, r *http.Request) {
+	var events []IngestedEvent
+	if err := json.NewDecoder(r.Body).Decode(&events); err != nil {
+		writeProblem(w, http.StatusBadRequest, "INVALID_BODY", "a JSON array of events is required")
+		return
+	}
+	if len(events) > maxIngestBatchSize {
+		writeProblem(w, http.StatusRequestEntityTooLarge, "BATCH_TOO_LARGE",
+			fmt.Sprintf("%d events received, a batch is limited to %d", len(events), maxIngestBatchSize))
+		return
+	}
+
+	results := make([]IngestResult, 0, len(events))
+	counts := map[string]int{ingestAccepted: 0, ingestDuplicate: 0, ingestRejected: 0}
+	for _, event := range events {
+		result := ingest(r.Context(), event)
+		counts[result.Result]++
+		results = append(results, result)
+	}
+	log.Printf("📨 Batch of %d events: %d accepted, %d duplicates, %d rejected",
+		len(events), counts[ingestAccepted], counts[ingestDuplicate], counts[ingestRejected])
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"accepted":   counts[ingestAccepted],
+		"duplicates": counts[ingestDuplicate],
+		"rejected":   counts[ingestRejected],
+		"results":    results,
+	})
+}
@@ -0,0 +1,257 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// signingKey is a key of the key ring. Keys loaded from a public key file only verify tokens.
+type signingKey struct {
+	ID      string
+	method  jwt.SigningMethod
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// keyRing holds the key signing some tokens, and every key accepted to verify them.
+// To rotate the signing key, add the new key to the keys directory and reload the keys;
+// tokens signed by the previous key stay valid until it is removed.
+type keyRing struct {
+	// name tells what the keys sign, and dirVariable configures their directory, for the logs
+	name        string
+	dirVariable string
+
+	mu      sync.RWMutex
+	signing *signingKey
+	keys    map[string]*signingKey
+}
+
+// jwtKeys sign the access tokens
+var jwtKeys = &keyRing{name: "tokens", dirVariable: "JWT_KEYS_DIR", keys: make(map[string]*signingKey)}
+
+// auditKeys sign the audit checkpoints. They are kept apart from the access token keys, as they must
+// verify the checkpoints as long as the audit log is kept, long after the rotation of the token keys.
+var auditKeys = &keyRing{name: "audit checkpoints", dirVariable: "AUDIT_KEYS_DIR", keys: make(map[string]*signingKey)}
+
+// load reads the PEM files of the keys directory, named {kid}.pem.
+// Unless a signing key is configured, tokens are signed by the private key with the greatest kid,
+// so that naming keys by date (2025-01.pem, 2025-07.pem) makes the latest one sign.
+// Without directory, an ephemeral Ed25519 key is generated, and tokens do not survive a restart.
+func (k *keyRing) load(conf KeysConfig) error {
+	keys := map[string]*signingKey{}
+
+	if conf.Dir == "" {
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		log.Printf("%s is not set, using an ephemeral key to sign the %s", k.dirVariable, k.name)
+		key := &signingKey{ID: "ephemeral", method: jwt.SigningMethodEdDSA, private: private, public: private.Public()}
+		keys[key.ID] = key
+		conf.SigningKey = key.ID
+	} else {
+		files, err := filepath.Glob(filepath.Join(conf.Dir, "*.pem"))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			key, err := readKey(file)
+			if err != nil {
+				return fmt.Errorf("%s: %w", file, err)
+			}
+			keys[key.ID] = key
+		}
+	}
+
+	if conf.SigningKey == "" {
+		for kid, key := range keys {
+			if key.private != nil && kid > conf.SigningKey {
+				conf.SigningKey = kid
+			}
+		}
+	}
+	signing, ok := keys[conf.SigningKey]
+	if !ok {
+		return fmt.Errorf("signing key %q not found in %s", conf.SigningKey, conf.Dir)
+	}
+	if signing.private == nil {
+		return fmt.Errorf("signing key %q has no private key", conf.SigningKey)
+	}
+
+	k.mu.Lock()
+	k.signing = signing
+	k.keys = keys
+	k.mu.Unlock()
+	log.Printf("🔑 Signing %s with key %s (%s), %d verification keys", k.name, signing.ID, signing.method.Alg(), len(keys))
+	return nil
+}
+
+// readKey parses an RSA or Ed25519 key, private or public, from a PEM file
+func readKey(file string) (*signingKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data")
+	}
+
+	key := &signingKey{ID: strings.TrimSuffix(filepath.Base(file), ".pem")}
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch parsed := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.method, key.private, key.public = jwt.SigningMethodRS256, parsed, parsed.Public()
+	case ed25519.PrivateKey:
+		key.method, key.private, key.public = jwt.SigningMethodEdDSA, parsed, parsed.Public()
+	case *rsa.PublicKey:
+		key.method, key.public = jwt.SigningMethodRS256, parsed
+	case ed25519.PublicKey:
+		key.method, key.public = jwt.SigningMethodEdDSA, parsed
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, expecting RSA or Ed25519", parsed)
+	}
+	return key, nil
+}
+
+// sign creates a token signed by the current signing key, identified by the kid header
+func (k *keyRing) sign(claims jwt.Claims) (string, error) {
+	k.mu.RLock()
+	key := k.signing
+	k.mu.RUnlock()
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.private)
+}
+
+// keyfunc returns the verification key of a token, found using its kid header
+func (k *keyRing) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	k.mu.RLock()
+	key, ok := k.keys[kid]
+	k.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("key %q does not use %s", kid, token.Method.Alg())
+	}
+	return key.public, nil
+}
+
+// parseToken verifies an access token and reads its claims.
+// Options are added to the default ones, such as jwt.WithoutClaimsValidation.
+func parseToken(tokenStr string, claims *Claims, options ...jwt.ParserOption) (*jwt.Token, error) {
+	options = append([]jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithExpirationRequired(),
+	}, options...)
+	return jwt.ParseWithClaims(tokenStr, claims, jwtKeys.keyfunc, options...)
+}
+
+// reloadKeysOnSignal reloads the keys when the server receives SIGHUP,
+// so that keys can be added or removed without a restart
+func reloadKeysOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		// ephemeral keys are kept: a new token key would invalidate the access tokens issued so far,
+		// and a new audit key would leave the checkpoints already signed unverifiable
+		if conf.Keys.Dir != "" {
+			if err := jwtKeys.load(conf.Keys); err != nil {
+				log.Printf("Error reloading the keys, keeping the current ones: %v", err)
+			}
+		}
+		if conf.Audit.Keys.Dir != "" {
+			if err := auditKeys.load(conf.Audit.Keys); err != nil {
+				log.Printf("Error reloading the audit keys, keeping the current ones: %v", err)
+			}
+		}
+	}
+}
+
+// JWK is a public key in the JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// JWKS publishes the public keys verifying the access tokens
+func JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	json.NewEncoder(w).Encode(map[string][]JWK{"keys": jwtKeys.jwks()})
+}
+
+// jwks returns the public keys of the key ring, in the JSON Web Key format
+func (k *keyRing) jwks() []JWK {
+	k.mu.RLock()
+	keys := []JWK{}
+	for _, key := range k.keys {
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.method.Alg()}
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		keys = append(keys, jwk)
+	}
+	k.mu.RUnlock()
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Kid < keys[j].Kid })
+	return keys
+}
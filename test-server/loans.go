@@ -0,0 +1,205 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// RenewRequest is the body of a renew request.
+// The new end date is either given explicitly, or as a number of days added to the current end date.
+// Override allows an administrator to go beyond the maximum end date of the license.
+type RenewRequest struct {
+	End      *time.Time `json:"end,omitempty"`
+	Days     int        `json:"days,omitempty"`
+	Override bool       `json:"override"`
+	Note     string     `json:"note"`
+}
+
+// ReturnRequest is the optional body of a forced return request
+type ReturnRequest struct {
+	Note string `json:"note"`
+}
+
+// RenewLicense extends the end date of a loan
+func RenewLicense(w http.ResponseWriter, r *http.Request) {
+	licenseID := chi.URLParam(r, "licenseID")
+	operator := claimsFromContext(r.Context()).Username
+
+	var req RenewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, "INVALID_BODY", "a JSON body with an end date or a number of days is required")
+		return
+	}
+	if (req.End == nil) == (req.Days == 0) {
+		writeProblem(w, http.StatusBadRequest, "INVALID_BODY", "either end or days must be set")
+		return
+	}
+	if req.Days < 0 {
+		writeProblem(w, http.StatusBadRequest, "INVALID_BODY", "days must be positive")
+		return
+	}
+	req.Note = strings.TrimSpace(req.Note)
+	if len(req.Note) > maxNoteLength {
+		writeProblem(w, http.StatusBadRequest, "INVALID_BODY", fmt.Sprintf("note must not exceed %d characters", maxNoteLength))
+		return
+	}
+	if req.Override && !isAdmin(r.Context()) {
+		writeProblem(w, http.StatusForbidden, "OVERRIDE_FORBIDDEN", "only an administrator can extend a license beyond its maximum end date")
+		return
+	}
+
+	rec, ok := store.get(r.Context(), licenseID)
+	if !ok {
+		writeProblem(w, http.StatusNotFound, "LICENSE_NOT_FOUND", fmt.Sprintf("license %s not found", licenseID))
+		return
+	}
+	newEnd, problem := renewal(&rec, req)
+	if problem != nil {
+		problem.write(w)
+		return
+	}
+
+	log.Printf("📅 Renewing license %s until %s", licenseID, newEnd.Format(time.RFC3339))
+
+	if err := lsd.Renew(r.Context(), licenseID, newEnd); err != nil {
+		log.Printf("LSD renewal of %s failed: %v", licenseID, err)
+		writeProblem(w, http.StatusBadGateway, "LSD_ERROR", "the license status server refused the renewal: "+err.Error())
+		return
+	}
+
+	// The checks are made again under the lock, as the license may have changed during the LSD call
+	err := store.update(r.Context(), licenseID, func(current *licenseRecord) error {
+		if current.End != rec.End {
+			return newProblem(http.StatusConflict, "LICENSE_CHANGED", "the end date of the license changed meanwhile, please retry")
+		}
+		if _, problem := renewal(current, req); problem != nil {
+			return problem
+		}
+		current.End = newEnd.UTC().Format(time.RFC3339)
+		reason := ""
+		if req.Override {
+			reason = "max_end_override"
+		}
+		current.addEvent("renew", operator, reason, req.Note)
+		return nil
+	})
+	if err != nil {
+		problemFrom(err).write(w)
+		return
+	}
+
+	license, _ := store.get(r.Context(), licenseID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(privacyFor(r.Context()).license(license.LicenseInfo))
+}
+
+// renewal checks that a license is a loan which can be renewed as requested, and returns its new end date
+func renewal(rec *licenseRecord, req RenewRequest) (time.Time, *Problem) {
+	if rec.Type != "loan" {
+		return time.Time{}, newProblem(http.StatusConflict, "LICENSE_NOT_A_LOAN", "only loans can be renewed")
+	}
+	if rec.Status != StatusReady && rec.Status != StatusActive {
+		return time.Time{}, newProblem(http.StatusConflict, "LICENSE_NOT_RENEWABLE", fmt.Sprintf("the license is %s and cannot be renewed", rec.Status))
+	}
+	currentEnd, err := time.Parse(time.RFC3339, rec.End)
+	if err != nil {
+		return time.Time{}, newProblem(http.StatusConflict, "LICENSE_NOT_A_LOAN", "the license has no end date")
+	}
+
+	newEnd := currentEnd.AddDate(0, 0, req.Days)
+	if req.End != nil {
+		newEnd = *req.End
+	}
+	if !newEnd.After(currentEnd) {
+		return time.Time{}, newProblem(http.StatusBadRequest, "INVALID_END", fmt.Sprintf("the new end date must be after %s", rec.End))
+	}
+	if rec.MaxEnd != nil && !req.Override {
+		maxEnd, err := time.Parse(time.RFC3339, *rec.MaxEnd)
+		if err == nil && newEnd.After(maxEnd) {
+			return time.Time{}, newProblem(http.StatusConflict, "MAX_END_EXCEEDED", fmt.Sprintf("the license cannot be extended beyond %s", *rec.MaxEnd))
+		}
+	}
+	return newEnd, nil
+}
+
+// ReturnLicense forces the return of a loan
+func ReturnLicense(w http.ResponseWriter, r *http.Request) {
+	licenseID := chi.URLParam(r, "licenseID")
+	operator := claimsFromContext(r.Context()).Username
+
+	var req ReturnRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeProblem(w, http.StatusBadRequest, "INVALID_BODY", "invalid return request")
+			return
+		}
+	}
+	req.Note = strings.TrimSpace(req.Note)
+	if len(req.Note) > maxNoteLength {
+		writeProblem(w, http.StatusBadRequest, "INVALID_BODY", fmt.Sprintf("note must not exceed %d characters", maxNoteLength))
+		return
+	}
+
+	rec, ok := store.get(r.Context(), licenseID)
+	if !ok {
+		writeProblem(w, http.StatusNotFound, "LICENSE_NOT_FOUND", fmt.Sprintf("license %s not found", licenseID))
+		return
+	}
+	if problem := checkReturnable(&rec); problem != nil {
+		problem.write(w)
+		return
+	}
+
+	log.Printf("📥 Forcing the return of license %s", licenseID)
+
+	if err := lsd.UpdateStatus(r.Context(), licenseID, StatusReturned, "returned by the provider"); err != nil {
+		log.Printf("LSD return of %s failed: %v", licenseID, err)
+		writeProblem(w, http.StatusBadGateway, "LSD_ERROR", "the license status server refused the return: "+err.Error())
+		return
+	}
+
+	// The checks are made again under the lock, as the license may have been revoked or returned during the LSD call
+	err := store.update(r.Context(), licenseID, func(rec *licenseRecord) error {
+		if problem := checkReturnable(rec); problem != nil {
+			return problem
+		}
+		rec.Status = StatusReturned
+		rec.addEvent("return", operator, "", req.Note)
+		return nil
+	})
+	if err != nil {
+		problemFrom(err).write(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"message":   "License return was successful",
+		"licenseID": licenseID,
+		"status":    StatusReturned,
+	})
+}
+
+// checkReturnable returns a problem if a license is not an active loan
+func checkReturnable(rec *licenseRecord) *Problem {
+	if rec.Type != "loan" {
+		return newProblem(http.StatusConflict, "LICENSE_NOT_A_LOAN", "only loans can be returned")
+	}
+	if rec.Status != StatusActive {
+		return newProblem(http.StatusConflict, "LICENSE_NOT_RETURNABLE", fmt.Sprintf("the license is %s and cannot be returned", rec.Status))
+	}
+	return nil
+}
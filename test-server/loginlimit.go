@@ -0,0 +1,253 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// loginBaseDelay is the delay imposed after the second consecutive failure of a username,
+// doubled after each further failure until the lockout
+const loginBaseDelay = time.Second
+
+// maxFailedLogins is the number of failed attempts kept for the administrators
+const maxFailedLogins = 1000
+
+// FailedLogin is a refused login attempt
+type FailedLogin struct {
+	Time     time.Time `json:"time"`
+	Username string    `json:"username"`
+	IP       string    `json:"ip"`
+	// Reason is invalid_credentials, invalid_code or rate_limited
+	Reason string `json:"reason"`
+}
+
+// LoginLock is a username or an IP address whose login attempts are refused until a date
+type LoginLock struct {
+	Username string    `json:"username,omitempty"`
+	IP       string    `json:"ip,omitempty"`
+	Failures int       `json:"failures"`
+	Until    time.Time `json:"until"`
+}
+
+// loginAttempts counts the recent failures of a username or an IP address
+type loginAttempts struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// loginLimiter slows down password guessing: each failure of a username delays its next attempt,
+// twice longer each time, and the username is locked out after MaxFailures failures.
+// An IP address is locked out after IPMaxFailures failures, whatever the usernames tried.
+// Failures older than the window are forgotten.
+type loginLimiter struct {
+	mu     sync.Mutex
+	users  map[string]*loginAttempts
+	ips    map[string]*loginAttempts
+	failed []FailedLogin
+}
+
+var loginLimits = &loginLimiter{
+	users: make(map[string]*loginAttempts),
+	ips:   make(map[string]*loginAttempts),
+}
+
+// retryAfter returns the delay before a login attempt is accepted for a username from an IP address, 0 if none
+func (l *loginLimiter) retryAfter(username, ip string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	wait := time.Duration(0)
+	if a, ok := l.users[username]; ok && a.blockedUntil.After(now) {
+		wait = a.blockedUntil.Sub(now)
+	}
+	if a, ok := l.ips[ip]; ok && a.blockedUntil.Sub(now) > wait {
+		wait = a.blockedUntil.Sub(now)
+	}
+	return wait
+}
+
+// recordFailure counts a failed attempt, and records it for the administrators
+func (l *loginLimiter) recordFailure(username, ip, reason string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.failed = append(l.failed, FailedLogin{Time: now, Username: username, IP: ip, Reason: reason})
+	if len(l.failed) > maxFailedLogins {
+		l.failed = l.failed[len(l.failed)-maxFailedLogins:]
+	}
+	log.Printf("🚨 Failed login for %q from %s: %s", username, ip, reason)
+
+	// attempts refused without checking the password are not failures
+	if reason == "rate_limited" {
+		return
+	}
+	l.prune(now)
+
+	limits := conf.LoginLimits
+	user := addFailure(l.users, username, now)
+	switch {
+	case user.failures >= limits.MaxFailures:
+		user.blockedUntil = now.Add(limits.Lockout)
+		log.Printf("🚨 Username %q locked out until %s after %d failures", username, user.blockedUntil.Format(time.RFC3339), user.failures)
+	case user.failures >= 2:
+		delay := loginBaseDelay * time.Duration(math.Pow(2, float64(user.failures-2)))
+		user.blockedUntil = now.Add(min(delay, limits.Lockout))
+	}
+
+	addr := addFailure(l.ips, ip, now)
+	if addr.failures >= limits.IPMaxFailures {
+		addr.blockedUntil = now.Add(limits.Lockout)
+		log.Printf("🚨 IP address %s locked out until %s after %d failures", ip, addr.blockedUntil.Format(time.RFC3339), addr.failures)
+	}
+}
+
+// addFailure adds a failure to the attempts of a key, and must be called with the lock held
+func addFailure(m map[string]*loginAttempts, key string, now time.Time) *loginAttempts {
+	a, ok := m[key]
+	if !ok {
+		a = &loginAttempts{}
+		m[key] = a
+	}
+	a.failures++
+	a.lastFailure = now
+	return a
+}
+
+// prune forgets the failures older than the window, and must be called with the lock held
+func (l *loginLimiter) prune(now time.Time) {
+	for _, m := range []map[string]*loginAttempts{l.users, l.ips} {
+		for key, a := range m {
+			if now.Sub(a.lastFailure) > conf.LoginLimits.Window && now.After(a.blockedUntil) {
+				delete(m, key)
+			}
+		}
+	}
+}
+
+// recordSuccess forgets the failures of a username after a successful login.
+// The failures of the IP address are kept, so that a valid account does not hide guesses on other ones.
+func (l *loginLimiter) recordSuccess(username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.users, username)
+}
+
+// unlock lifts the lockout of a username, and tells if it was locked out
+func (l *loginLimiter) unlock(username string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	a, ok := l.users[username]
+	delete(l.users, username)
+	return ok && a.blockedUntil.After(time.Now())
+}
+
+// locks returns the usernames and IP addresses currently refused
+func (l *loginLimiter) locks() []LoginLock {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	list := []LoginLock{}
+	for username, a := range l.users {
+		if a.blockedUntil.After(now) {
+			list = append(list, LoginLock{Username: username, Failures: a.failures, Until: a.blockedUntil})
+		}
+	}
+	for ip, a := range l.ips {
+		if a.blockedUntil.After(now) {
+			list = append(list, LoginLock{IP: ip, Failures: a.failures, Until: a.blockedUntil})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Until.After(list[j].Until) })
+	return list
+}
+
+// refuseLogin answers a login attempt made during a delay or a lockout, and records it
+func refuseLogin(w http.ResponseWriter, username, ip string, wait time.Duration) {
+	loginLimits.recordFailure(username, ip, "rate_limited")
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeProblem(w, http.StatusTooManyRequests, "TOO_MANY_ATTEMPTS",
+		fmt.Sprintf("too many failed login attempts, retry in %d seconds", seconds))
+}
+
+// clientIP returns the IP address of the client of a request.
+// The X-Forwarded-For and X-Real-IP headers are only used behind a trusted reverse proxy,
+// as clients could otherwise choose their address. The proxy appends the address of its client
+// to X-Forwarded-For, the previous entries are sent by the client.
+func clientIP(r *http.Request) string {
+	if conf.TrustProxyHeaders {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			entries := strings.Split(xff, ",")
+			return strings.TrimSpace(entries[len(entries)-1])
+		}
+		if ip := r.Header.Get("X-Real-IP"); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// FailedLogins lists the recent failed login attempts, most recent first, and the current lockouts.
+// The username and ip parameters filter the attempts.
+func FailedLogins(w http.ResponseWriter, r *http.Request) {
+	username := r.URL.Query().Get("username")
+	ip := r.URL.Query().Get("ip")
+
+	loginLimits.mu.Lock()
+	failures := []FailedLogin{}
+	for i := len(loginLimits.failed) - 1; i >= 0; i-- {
+		f := loginLimits.failed[i]
+		if (username == "" || f.Username == username) && (ip == "" || f.IP == ip) {
+			failures = append(failures, f)
+		}
+	}
+	loginLimits.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"failures": failures,
+		"locks":    loginLimits.locks(),
+	})
+}
+
+// UnlockAccount lifts the lockout of a username before its end
+func UnlockAccount(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+	if !loginLimits.unlock(username) {
+		writeProblem(w, http.StatusNotFound, "NOT_LOCKED", fmt.Sprintf("%s is not locked out", username))
+		return
+	}
+	log.Printf("🔐 Lockout of %s lifted by %s", username, claimsFromContext(r.Context()).Username)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Account unlocked",
+	})
+}
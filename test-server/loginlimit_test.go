@@ -0,0 +1,85 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newLoginLimiter returns a login limiter without failures, locking out after 5 failures of a username
+// or 8 failures from an IP address
+func newLoginLimiter(t *testing.T) *loginLimiter {
+	saved := conf.LoginLimits
+	t.Cleanup(func() { conf.LoginLimits = saved })
+	conf.LoginLimits = LoginLimitsConfig{MaxFailures: 5, IPMaxFailures: 8, Lockout: 15 * time.Minute, Window: 15 * time.Minute}
+	return &loginLimiter{users: make(map[string]*loginAttempts), ips: make(map[string]*loginAttempts)}
+}
+
+func TestLoginDelays(t *testing.T) {
+	l := newLoginLimiter(t)
+
+	l.recordFailure("alice", "192.0.2.1", "invalid_credentials")
+	if wait := l.retryAfter("alice", "192.0.2.1"); wait != 0 {
+		t.Errorf("delay after a failure = %s, want none", wait)
+	}
+	// attempts refused without checking the password are not failures
+	l.recordFailure("alice", "192.0.2.1", "rate_limited")
+	if wait := l.retryAfter("alice", "192.0.2.1"); wait != 0 {
+		t.Errorf("delay after a rate limited attempt = %s, want none", wait)
+	}
+
+	l.recordFailure("alice", "192.0.2.1", "invalid_credentials")
+	l.recordFailure("alice", "192.0.2.1", "invalid_credentials")
+	l.recordFailure("alice", "192.0.2.1", "invalid_credentials")
+	if wait := l.retryAfter("alice", "192.0.2.1"); wait <= 3*loginBaseDelay || wait > 4*loginBaseDelay {
+		t.Errorf("delay after 4 failures = %s, want %s", wait, 4*loginBaseDelay)
+	}
+
+	l.recordSuccess("alice")
+	if wait := l.retryAfter("alice", "192.0.2.1"); wait != 0 {
+		t.Errorf("delay after a successful login = %s, want none", wait)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	l := newLoginLimiter(t)
+
+	for i := 0; i < 5; i++ {
+		l.recordFailure("alice", "192.0.2.1", "invalid_credentials")
+	}
+	if wait := l.retryAfter("alice", "198.51.100.1"); wait < 14*time.Minute {
+		t.Errorf("delay of a locked out username from another address = %s, want 15m", wait)
+	}
+	if wait := l.retryAfter("bob", "198.51.100.1"); wait != 0 {
+		t.Errorf("delay of another username = %s, want none", wait)
+	}
+	if !l.unlock("alice") || l.retryAfter("alice", "198.51.100.1") != 0 {
+		t.Error("unlock did not lift the lockout")
+	}
+}
+
+func TestLoginIPLockout(t *testing.T) {
+	l := newLoginLimiter(t)
+
+	// one failure on each of 8 usernames locks the address out
+	for i := 0; i < 8; i++ {
+		l.recordFailure(fmt.Sprintf("user%d", i), "192.0.2.1", "invalid_credentials")
+	}
+	if wait := l.retryAfter("nobody", "192.0.2.1"); wait < 14*time.Minute {
+		t.Errorf("delay from a locked out address = %s, want 15m", wait)
+	}
+	// a valid account does not lift the lockout of the address
+	l.recordSuccess("user0")
+	if wait := l.retryAfter("user0", "192.0.2.1"); wait < 14*time.Minute {
+		t.Errorf("delay after a successful login from a locked out address = %s, want 15m", wait)
+	}
+	if wait := l.retryAfter("user0", "198.51.100.1"); wait != 0 {
+		t.Errorf("delay from another address = %s, want none", wait)
+	}
+}
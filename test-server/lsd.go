@@ -0,0 +1,121 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// LSDClient calls the status endpoint of a License Status Document server.
+type LSDClient struct {
+	BaseURL    string
+	Username   string
+	Password   string
+	HTTPClient *http.Client
+}
+
+// LSDError is returned when the LSD server refuses a status update.
+type LSDError struct {
+	StatusCode int
+	Detail     string
+}
+
+func (e *LSDError) Error() string {
+	return fmt.Sprintf("lsd server returned %d: %s", e.StatusCode, e.Detail)
+}
+
+// statusUpdate is the body of a status update sent to the LSD server
+type statusUpdate struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// lsd is the LSD client used by the handlers, set in main
+var lsd *LSDClient
+
+func newLSDClient(conf LSDConfig) *LSDClient {
+	return &LSDClient{
+		BaseURL:    strings.TrimSuffix(conf.BaseURL, "/"),
+		Username:   conf.Username,
+		Password:   conf.Password,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// UpdateStatus asks the LSD server to move a license to a new status,
+// using PATCH {base}/licenses/{id}/status.
+func (c *LSDClient) UpdateStatus(ctx context.Context, licenseID, status, message string) error {
+	body, err := json.Marshal(statusUpdate{Status: status, Message: message})
+	if err != nil {
+		return err
+	}
+
+	endpoint := c.BaseURL + "/licenses/" + url.PathEscape(licenseID) + "/status"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return c.do(req)
+}
+
+// Renew asks the LSD server to extend the end date of a loan,
+// using PUT {base}/licenses/{id}/renew?end={end}.
+func (c *LSDClient) Renew(ctx context.Context, licenseID string, end time.Time) error {
+	endpoint := c.BaseURL + "/licenses/" + url.PathEscape(licenseID) + "/renew?end=" + url.QueryEscape(end.UTC().Format(time.RFC3339))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req)
+}
+
+// DeregisterDevice asks the LSD server to forget a device registered on a license,
+// using DELETE {base}/licenses/{id}/devices/{deviceID}.
+func (c *LSDClient) DeregisterDevice(ctx context.Context, licenseID, deviceID string) error {
+	endpoint := c.BaseURL + "/licenses/" + url.PathEscape(licenseID) + "/devices/" + url.PathEscape(deviceID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req)
+}
+
+// do sends an authenticated request and turns error responses into an LSDError
+func (c *LSDClient) do(req *http.Request) error {
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		lsdErr := &LSDError{StatusCode: resp.StatusCode}
+		var p Problem
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(data, &p) == nil && p.Detail != "" {
+			lsdErr.Detail = p.Detail
+		} else {
+			lsdErr.Detail = strings.TrimSpace(string(data))
+		}
+		return lsdErr
+	}
+	return nil
+}
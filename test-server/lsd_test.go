@@ -0,0 +1,50 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLSDClientUpdateStatus(t *testing.T) {
+	stub := newLSDStub(LSDConfig{Username: "lsd", Password: "secret"})
+	server := httptest.NewServer(stub.routes())
+	defer server.Close()
+	client := newLSDClient(LSDConfig{BaseURL: server.URL + "/", Username: "lsd", Password: "secret"})
+	ctx := context.Background()
+
+	if err := client.UpdateStatus(ctx, "lic-001", StatusRevoked, "revoked: fraud"); err != nil {
+		t.Fatalf("revocation of an active license: %v", err)
+	}
+	if stub.statuses["lic-001"] != StatusRevoked {
+		t.Errorf("status = %s, want %s", stub.statuses["lic-001"], StatusRevoked)
+	}
+
+	// a refused update is reported with the status code and the detail of the problem
+	var lsdErr *LSDError
+	err := client.UpdateStatus(ctx, "lic-004", StatusRevoked, "revoked: fraud")
+	if !errors.As(err, &lsdErr) || lsdErr.StatusCode != http.StatusBadRequest || lsdErr.Detail != "license is expired and cannot become revoked" {
+		t.Errorf("revocation of an expired license = %v", err)
+	}
+	err = client.UpdateStatus(ctx, "lic-999", StatusRevoked, "")
+	if !errors.As(err, &lsdErr) || lsdErr.StatusCode != http.StatusNotFound {
+		t.Errorf("revocation of an unknown license = %v", err)
+	}
+
+	client.Password = "wrong"
+	err = client.UpdateStatus(ctx, "lic-003", StatusRevoked, "")
+	if !errors.As(err, &lsdErr) || lsdErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("revocation with wrong credentials = %v", err)
+	}
+	if stub.statuses["lic-003"] != StatusActive {
+		t.Errorf("status = %s after a refused update, want %s", stub.statuses["lic-003"], StatusActive)
+	}
+}
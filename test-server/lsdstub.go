@@ -0,0 +1,192 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// lsdStub is a local stand-in of an LSD server, used when no LSD server is configured.
+// It knows the status of the licenses of the store, including the licenses created
+// and the status changes ingested after its start.
+type lsdStub struct {
+	username string
+	password string
+
+	mu       sync.Mutex
+	statuses map[string]string
+}
+
+// lsdTransitions lists the status updates accepted by the stand-in, per current status
+var lsdTransitions = map[string][]string{
+	StatusReady:  {StatusRevoked, StatusCancelled, StatusActive},
+	StatusActive: {StatusRevoked, StatusReturned, StatusExpired},
+	// reinstatement of a license revoked by mistake
+	StatusRevoked: {StatusReady, StatusActive},
+}
+
+func newLSDStub(conf LSDConfig) *lsdStub {
+	s := &lsdStub{
+		username: conf.Username,
+		password: conf.Password,
+		statuses: make(map[string]string),
+	}
+	for _, rec := range store.list(context.Background(), nil) {
+		s.statuses[rec.UUID] = rec.Status
+	}
+	return s
+}
+
+// onActivity follows the licenses created and the status changes made outside of the stand-in,
+// such as the licenses of the activity simulator and the ingested events
+func (s *lsdStub) onActivity(a Activity) {
+	if a.Type != ActivityLicenseCreated && a.Type != ActivityStatusChanged {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses[a.LicenseID] = a.Status
+}
+
+func (s *lsdStub) routes() http.Handler {
+	r := chi.NewRouter()
+	r.Use(s.basicAuth)
+	r.Get("/licenses/{licenseID}/status", s.getStatus)
+	r.Patch("/licenses/{licenseID}/status", s.patchStatus)
+	r.Put("/licenses/{licenseID}/renew", s.renew)
+	r.Delete("/licenses/{licenseID}/devices/{deviceID}", s.deregisterDevice)
+	return r
+}
+
+// start serves the stand-in in the background
+func (s *lsdStub) start(addr string) {
+	log.Println("LSD server stand-in started on", addr)
+	go func() {
+		if err := http.ListenAndServe(addr, s.routes()); err != nil {
+			log.Fatal("Error starting LSD server stand-in:", err)
+		}
+	}()
+}
+
+func (s *lsdStub) basicAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != s.username || password != s.password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="lsd"`)
+			writeProblem(w, http.StatusUnauthorized, "", "invalid LSD credentials")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *lsdStub) getStatus(w http.ResponseWriter, r *http.Request) {
+	licenseID := chi.URLParam(r, "licenseID")
+
+	s.mu.Lock()
+	status, ok := s.statuses[licenseID]
+	s.mu.Unlock()
+	if !ok {
+		writeProblem(w, http.StatusNotFound, "", "license not found")
+		return
+	}
+	s.writeStatusDocument(w, licenseID, status)
+}
+
+func (s *lsdStub) patchStatus(w http.ResponseWriter, r *http.Request) {
+	licenseID := chi.URLParam(r, "licenseID")
+
+	var update statusUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		writeProblem(w, http.StatusBadRequest, "", "invalid status update")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.statuses[licenseID]
+	if !ok {
+		writeProblem(w, http.StatusNotFound, "", "license not found")
+		return
+	}
+	allowed := false
+	for _, next := range lsdTransitions[current] {
+		if next == update.Status {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		writeProblem(w, http.StatusBadRequest, "", fmt.Sprintf("license is %s and cannot become %s", current, update.Status))
+		return
+	}
+
+	log.Printf("LSD stand-in: license %s %s -> %s (%s)", licenseID, current, update.Status, update.Message)
+	s.statuses[licenseID] = update.Status
+	s.writeStatusDocument(w, licenseID, update.Status)
+}
+
+func (s *lsdStub) renew(w http.ResponseWriter, r *http.Request) {
+	licenseID := chi.URLParam(r, "licenseID")
+
+	end, err := time.Parse(time.RFC3339, r.URL.Query().Get("end"))
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "", "invalid end date")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status, ok := s.statuses[licenseID]
+	if !ok {
+		writeProblem(w, http.StatusNotFound, "", "license not found")
+		return
+	}
+	if status != StatusReady && status != StatusActive {
+		writeProblem(w, http.StatusBadRequest, "", fmt.Sprintf("license is %s and cannot be renewed", status))
+		return
+	}
+
+	log.Printf("LSD stand-in: license %s renewed until %s", licenseID, end.Format(time.RFC3339))
+	s.writeStatusDocument(w, licenseID, status)
+}
+
+func (s *lsdStub) deregisterDevice(w http.ResponseWriter, r *http.Request) {
+	licenseID := chi.URLParam(r, "licenseID")
+
+	s.mu.Lock()
+	status, ok := s.statuses[licenseID]
+	s.mu.Unlock()
+	if !ok {
+		writeProblem(w, http.StatusNotFound, "", "license not found")
+		return
+	}
+
+	log.Printf("LSD stand-in: device %s deregistered from license %s", chi.URLParam(r, "deviceID"), licenseID)
+	s.writeStatusDocument(w, licenseID, status)
+}
+
+func (s *lsdStub) writeStatusDocument(w http.ResponseWriter, licenseID, status string) {
+	w.Header().Set("Content-Type", "application/vnd.readium.license.status.v1.0+json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":     licenseID,
+		"status": status,
+		"updated": map[string]string{
+			"status": time.Now().Format(time.RFC3339),
+		},
+	})
+}
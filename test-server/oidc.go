@@ -0,0 +1,555 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// oidcStateCookie binds a pending login to the browser which started it
+const oidcStateCookie = "oidc_state"
+
+// oidcLoginTTL is the time given to an operator to log in on the identity provider
+const oidcLoginTTL = 10 * time.Minute
+
+// oidcError is a login failure reported to the dashboard, as the sso_error parameter of the post-login URL
+type oidcError struct {
+	Code   string
+	Detail string
+}
+
+func (e *oidcError) Error() string {
+	return e.Code + ": " + e.Detail
+}
+
+// oidcDiscovery is the part of the OpenID provider metadata used by the login flow
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcLogin is a login started on the identity provider, waiting for its callback
+type oidcLogin struct {
+	nonce     string
+	verifier  string
+	createdAt time.Time
+}
+
+// oidcClient runs the authorization code flow with PKCE against the configured identity provider
+type oidcClient struct {
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]interface{}
+	pending   map[string]oidcLogin
+}
+
+var oidc = &oidcClient{
+	httpClient: &http.Client{Timeout: 10 * time.Second},
+	keys:       make(map[string]interface{}),
+	pending:    make(map[string]oidcLogin),
+}
+
+// enabled tells if single sign-on is configured
+func (c *oidcClient) enabled() bool {
+	return conf.OIDC.Issuer != ""
+}
+
+// discover fetches the provider metadata, which is kept once read
+func (c *oidcClient) discover(ctx context.Context) (*oidcDiscovery, error) {
+	c.mu.Lock()
+	d := c.discovery
+	c.mu.Unlock()
+	if d != nil {
+		return d, nil
+	}
+
+	d = &oidcDiscovery{}
+	if err := c.getJSON(ctx, conf.OIDC.Issuer+"/.well-known/openid-configuration", d); err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != conf.OIDC.Issuer {
+		return nil, fmt.Errorf("discovery: issuer %q does not match %q", d.Issuer, conf.OIDC.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("discovery: missing endpoints")
+	}
+
+	c.mu.Lock()
+	c.discovery = d
+	c.mu.Unlock()
+	return d, nil
+}
+
+// getJSON reads a JSON document from the identity provider
+func (c *oidcClient) getJSON(ctx context.Context, endpoint string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", endpoint, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// start records a pending login, and returns the URL of the identity provider the browser is sent to
+func (c *oidcClient) start(ctx context.Context) (state, authURL string, err error) {
+	d, err := c.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, nonce, verifier := randomToken(), randomToken(), randomToken()
+	challenge := sha256.Sum256([]byte(verifier))
+
+	now := time.Now()
+	c.mu.Lock()
+	for s, login := range c.pending {
+		if now.Sub(login.createdAt) > oidcLoginTTL {
+			delete(c.pending, s)
+		}
+	}
+	c.pending[state] = oidcLogin{nonce: nonce, verifier: verifier, createdAt: now}
+	c.mu.Unlock()
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {conf.OIDC.ClientID},
+		"redirect_uri":          {conf.OIDC.RedirectURL},
+		"scope":                 {"openid profile email"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return state, d.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// finish exchanges the authorization code of a pending login, and returns the claims of the validated ID token
+func (c *oidcClient) finish(ctx context.Context, state, code string) (jwt.MapClaims, error) {
+	c.mu.Lock()
+	login, ok := c.pending[state]
+	delete(c.pending, state)
+	c.mu.Unlock()
+	if !ok || time.Since(login.createdAt) > oidcLoginTTL {
+		return nil, &oidcError{"SSO_STATE_INVALID", "the login expired or was not started by this browser"}
+	}
+
+	d, err := c.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {conf.OIDC.RedirectURL},
+		"code_verifier": {login.verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(conf.OIDC.ClientID), url.QueryEscape(conf.OIDC.ClientSecret))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("token endpoint returned %d: %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return nil, &oidcError{"SSO_CODE_REJECTED", fmt.Sprintf("the identity provider refused the code: %s %s", tokens.Error, tokens.ErrorDescription)}
+	}
+
+	return c.verifyIDToken(ctx, d, tokens.IDToken, login.nonce)
+}
+
+// verifyIDToken checks the signature, issuer, audience, expiration and nonce of an ID token
+func (c *oidcClient) verifyIDToken(ctx context.Context, d *oidcDiscovery, idToken, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims,
+		func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			return c.key(ctx, d, kid)
+		},
+		jwt.WithValidMethods([]string{"RS256", "ES256", "EdDSA"}),
+		jwt.WithIssuer(d.Issuer),
+		jwt.WithAudience(conf.OIDC.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, &oidcError{"SSO_TOKEN_INVALID", err.Error()}
+	}
+	if claims["nonce"] != nonce {
+		return nil, &oidcError{"SSO_TOKEN_INVALID", "nonce mismatch"}
+	}
+	// a token issued to several audiences must name the dashboard as its authorized party
+	if aud, _ := claims.GetAudience(); len(aud) > 1 && claims["azp"] != conf.OIDC.ClientID {
+		return nil, &oidcError{"SSO_TOKEN_INVALID", "token not issued to the dashboard"}
+	}
+	if sub, _ := claims.GetSubject(); sub == "" {
+		return nil, &oidcError{"SSO_TOKEN_INVALID", "missing subject"}
+	}
+	return claims, nil
+}
+
+// key returns a verification key of the identity provider.
+// The key set is fetched again when a kid is unknown, so that key rotations are followed.
+func (c *oidcClient) key(ctx context.Context, d *oidcDiscovery, kid string) (interface{}, error) {
+	c.mu.Lock()
+	key, ok := c.keys[kid]
+	c.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	var set struct {
+		Keys []JWK `json:"keys"`
+	}
+	if err := c.getJSON(ctx, d.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+	keys := map[string]interface{}{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		public, err := jwk.publicKey()
+		if err != nil {
+			log.Printf("🔐 Ignoring key %s of the identity provider: %v", jwk.Kid, err)
+			continue
+		}
+		keys[jwk.Kid] = public
+	}
+
+	c.mu.Lock()
+	c.keys = keys
+	c.mu.Unlock()
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// publicKey decodes an RSA, EC P-256 or Ed25519 public key
+func (k JWK) publicKey() (interface{}, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("point not on curve")
+		}
+		return key, nil
+	case "OKP":
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if k.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+// roleFromGroups returns the role granted by the groups of an operator, or "" if none.
+// An account has a single role: an operator in several groups gets the role coming first
+// in the configured precedence, the permissions of the other roles are not combined.
+// Keycloak may send groups as paths (/dashboard-admins), the leading slash is ignored.
+func roleFromGroups(groups []string) string {
+	precedence := conf.OIDC.RolePrecedence
+	role, rank := "", len(precedence)+1
+	for _, group := range groups {
+		r, ok := conf.OIDC.GroupRoles[strings.TrimPrefix(group, "/")]
+		if !ok {
+			continue
+		}
+		// roles missing from the precedence come last
+		i := slices.Index(precedence, r)
+		if i < 0 {
+			i = len(precedence)
+		}
+		if i < rank {
+			role, rank = r, i
+		}
+	}
+	return role
+}
+
+// stringList reads a claim holding a string or a list of strings
+func stringList(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		list := []string{}
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// ssoAccount creates or updates the account of an operator authenticated by the identity provider.
+// The role follows the groups of the operator on every login; the providers are set by the administrators.
+// An existing password account is never taken over by a single sign-on.
+func ssoAccount(claims jwt.MapClaims) (Account, error) {
+	subject, _ := claims.GetSubject()
+	username, _ := claims["preferred_username"].(string)
+	if !usernamePattern.MatchString(username) {
+		username = subject
+	}
+	if !usernamePattern.MatchString(username) {
+		return Account{}, &oidcError{"SSO_USERNAME_INVALID", "the identity provider sent no usable username"}
+	}
+	name, _ := claims["name"].(string)
+	email, _ := claims["email"].(string)
+
+	role := roleFromGroups(stringList(claims[conf.OIDC.GroupsClaim]))
+	if role == "" {
+		return Account{}, &oidcError{"SSO_NO_ROLE", fmt.Sprintf("%s belongs to no group granting access to the dashboard", username)}
+	}
+
+	now := time.Now()
+	refresh := func(a *Account) error {
+		if a.SSOSubject != subject {
+			return &oidcError{"SSO_ACCOUNT_CONFLICT", fmt.Sprintf("account %s is not bound to this identity", username)}
+		}
+		if a.Disabled {
+			return &oidcError{"ACCOUNT_DISABLED", fmt.Sprintf("account %s is disabled", username)}
+		}
+		if name != "" {
+			a.Name = name
+		}
+		if email != "" {
+			a.Email = email
+		}
+		a.Role = role
+		a.LastLoginAt = &now
+		return nil
+	}
+
+	account, err := accounts.update(username, refresh)
+	if errors.Is(err, errAccountNotFound) {
+		a := &Account{
+			Username:    username,
+			Name:        username,
+			SSOSubject:  subject,
+			CreatedAt:   now,
+			LastLoginAt: &now,
+		}
+		refresh(a)
+		if err = accounts.create(a); err == nil {
+			log.Printf("👤 Account %s created by single sign-on", username)
+			return a.view(), nil
+		}
+	}
+	return account, err
+}
+
+// ssoMFA tells if the identity provider confirmed the login with a second factor,
+// using the authentication methods (RFC 8176) of the ID token
+func ssoMFA(claims jwt.MapClaims) bool {
+	for _, method := range stringList(claims["amr"]) {
+		if method == "mfa" || method == "otp" {
+			return true
+		}
+	}
+	return false
+}
+
+// randomToken returns a random URL-safe string, used as state, nonce and PKCE verifier
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ssoRedirect sends the browser back to the dashboard, with an error code if the login failed
+func ssoRedirect(w http.ResponseWriter, r *http.Request, code string) {
+	target, err := url.Parse(conf.OIDC.PostLoginURL)
+	if err != nil {
+		http.Error(w, "Invalid post-login URL", http.StatusInternalServerError)
+		return
+	}
+	q := target.Query()
+	if code == "" {
+		q.Set("sso", "1")
+	} else {
+		q.Set("sso_error", code)
+		auditFailure(r, code)
+	}
+	target.RawQuery = q.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// OIDCStatus tells the dashboard if single sign-on is available
+func OIDCStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"enabled": oidc.enabled()})
+}
+
+// OIDCLogin starts a single sign-on, redirecting the browser to the identity provider
+func OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if !oidc.enabled() {
+		writeProblem(w, http.StatusNotFound, "SSO_DISABLED", "single sign-on is not configured")
+		return
+	}
+
+	state, authURL, err := oidc.start(r.Context())
+	if err != nil {
+		log.Printf("🔐 Single sign-on unavailable: %v", err)
+		ssoRedirect(w, r, "SSO_UNAVAILABLE")
+		return
+	}
+
+	// Lax, as the callback is a navigation coming from the identity provider
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/dashdata/oidc",
+		MaxAge:   int(oidcLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   false, // false for local development
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback completes a single sign-on: the authorization code is exchanged for an ID token,
+// the account of the operator is created or updated, and a session is opened like after a password login.
+// The browser is then sent back to the dashboard, which gets its access token from /dashdata/refresh.
+func OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if !oidc.enabled() {
+		writeProblem(w, http.StatusNotFound, "SSO_DISABLED", "single sign-on is not configured")
+		return
+	}
+	clearCookie(w, oidcStateCookie, "/dashdata/oidc")
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		log.Printf("🔐 Single sign-on refused by the identity provider: %s %s", e, q.Get("error_description"))
+		ssoRedirect(w, r, "SSO_DENIED")
+		return
+	}
+	state := q.Get("state")
+	if c, err := r.Cookie(oidcStateCookie); err != nil || state == "" || c.Value != state {
+		log.Println("🔐 Single sign-on refused: state mismatch")
+		ssoRedirect(w, r, "SSO_STATE_INVALID")
+		return
+	}
+
+	var mfa bool
+	account, err := func() (Account, error) {
+		claims, err := oidc.finish(r.Context(), state, q.Get("code"))
+		if err != nil {
+			return Account{}, err
+		}
+		mfa = ssoMFA(claims)
+		return ssoAccount(claims)
+	}()
+	if err != nil {
+		log.Printf("🔐 Single sign-on refused: %v", err)
+		var oe *oidcError
+		if errors.As(err, &oe) {
+			ssoRedirect(w, r, oe.Code)
+		} else {
+			ssoRedirect(w, r, "SSO_FAILED")
+		}
+		return
+	}
+
+	log.Println("🔐 User (single sign-on):", account.Username)
+	auditOperator(r, account.Username)
+
+	sess, refreshToken, err := sessions.create(account.Username, mfa)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	tokenString, expirationTime, err := issueToken(account, sess)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	setTokenCookies(w, tokenString, expirationTime, refreshToken, sess.ExpiresAt)
+	ssoRedirect(w, r, "")
+}
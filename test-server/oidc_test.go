@@ -0,0 +1,36 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import "testing"
+
+func TestRoleFromGroups(t *testing.T) {
+	defer func(oidc OIDCConfig) { conf.OIDC = oidc }(conf.OIDC)
+	conf.OIDC.GroupRoles = map[string]string{
+		"dashboard-admins":       RoleAdmin,
+		"dashboard-support":      RoleSupport,
+		"dashboard-publications": RolePublicationManager,
+		"dashboard-viewers":      RoleViewer,
+	}
+	conf.OIDC.RolePrecedence = []string{RoleAdmin, RoleSupport, RolePublicationManager, RoleViewer}
+
+	if role := roleFromGroups([]string{"/dashboard-viewers"}); role != RoleViewer {
+		t.Errorf("role of a group path = %q, want %q", role, RoleViewer)
+	}
+	if role := roleFromGroups([]string{"staff", "students"}); role != "" {
+		t.Errorf("role of unmapped groups = %q, want none", role)
+	}
+	if role := roleFromGroups([]string{"dashboard-publications", "dashboard-support"}); role != RoleSupport {
+		t.Errorf("role of two groups = %q, want %q", role, RoleSupport)
+	}
+
+	// the precedence decides, whatever the order of the groups
+	conf.OIDC.RolePrecedence = []string{RoleAdmin, RolePublicationManager, RoleSupport, RoleViewer}
+	if role := roleFromGroups([]string{"dashboard-support", "dashboard-publications"}); role != RolePublicationManager {
+		t.Errorf("role with a configured precedence = %q, want %q", role, RolePublicationManager)
+	}
+}
@@ -0,0 +1,277 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// oidcMockUser is an operator known by the mock identity provider
+type oidcMockUser struct {
+	Subject string
+	Name    string
+	Email   string
+	Groups  []string
+}
+
+// oidcMockUsers are the operators of the mock identity provider, one per dashboard role,
+// and one without access to the dashboard
+var oidcMockUsers = map[string]oidcMockUser{
+	"alice": {Subject: "5f1c2a9e-alice", Name: "Alice Admin", Email: "alice@example.com", Groups: []string{"/dashboard-admins", "/staff"}},
+	"bob":   {Subject: "8b3d4e7f-bob", Name: "Bob Support", Email: "bob@example.com", Groups: []string{"/dashboard-support"}},
+	"carol": {Subject: "2c6a9b1d-carol", Name: "Carol Viewer", Email: "carol@example.com", Groups: []string{"/dashboard-viewers"}},
+	"dave":  {Subject: "9d7e3f2a-dave", Name: "Dave Outsider", Email: "dave@example.com", Groups: []string{"/staff"}},
+}
+
+// oidcMockCode is an authorization code waiting to be exchanged
+type oidcMockCode struct {
+	username    string
+	redirectURI string
+	challenge   string
+	nonce       string
+	expiresAt   time.Time
+}
+
+// oidcMock is a local stand-in of an OpenID Connect provider such as Keycloak, used to test single sign-on.
+// It implements the authorization code flow with PKCE for the configured client, and lets anybody
+// log in as one of its users: the user is picked on a page, or given as the login_hint parameter.
+type oidcMock struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	key          *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]oidcMockCode
+}
+
+func newOIDCMock(conf OIDCConfig) *oidcMock {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal("Error generating the key of the OIDC provider stand-in:", err)
+	}
+	return &oidcMock{
+		issuer:       "http://" + conf.MockAddr,
+		clientID:     conf.ClientID,
+		clientSecret: conf.ClientSecret,
+		key:          key,
+		codes:        make(map[string]oidcMockCode),
+	}
+}
+
+func (m *oidcMock) routes() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/.well-known/openid-configuration", m.discovery)
+	r.Get("/authorize", m.authorize)
+	r.Post("/token", m.token)
+	r.Get("/jwks", m.jwks)
+	return r
+}
+
+// start serves the stand-in in the background
+func (m *oidcMock) start(addr string) {
+	log.Println("OIDC provider stand-in started on", addr)
+	go func() {
+		if err := http.ListenAndServe(addr, m.routes()); err != nil {
+			log.Fatal("Error starting OIDC provider stand-in:", err)
+		}
+	}()
+}
+
+func (m *oidcMock) discovery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                                m.issuer,
+		"authorization_endpoint":                m.issuer + "/authorize",
+		"token_endpoint":                        m.issuer + "/token",
+		"jwks_uri":                              m.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+var oidcMockLoginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html><head><title>Mock identity provider</title></head>
+<body>
+<h1>Mock identity provider</h1>
+<p>Log in to the LCP dashboard as:</p>
+<ul>
+{{range .Users}}<li><a href="{{.URL}}">{{.Name}}</a> ({{.Groups}})</li>
+{{end}}</ul>
+</body></html>
+`))
+
+// authorize issues an authorization code for the user given as login_hint,
+// or lets the user pick an account
+func (m *oidcMock) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectURI := q.Get("redirect_uri")
+	if q.Get("client_id") != m.clientID || redirectURI == "" {
+		http.Error(w, "unknown client or missing redirect_uri", http.StatusBadRequest)
+		return
+	}
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	params := url.Values{"state": {q.Get("state")}}
+	reply := func() {
+		target.RawQuery = params.Encode()
+		http.Redirect(w, r, target.String(), http.StatusFound)
+	}
+
+	if q.Get("response_type") != "code" {
+		params.Set("error", "unsupported_response_type")
+		reply()
+		return
+	}
+	if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		params.Set("error", "invalid_request")
+		params.Set("error_description", "PKCE with S256 is required")
+		reply()
+		return
+	}
+
+	username := q.Get("login_hint")
+	if _, ok := oidcMockUsers[username]; !ok {
+		m.loginPage(w, r)
+		return
+	}
+
+	code := randomToken()
+	m.mu.Lock()
+	m.codes[code] = oidcMockCode{
+		username:    username,
+		redirectURI: redirectURI,
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+		expiresAt:   time.Now().Add(time.Minute),
+	}
+	m.mu.Unlock()
+
+	params.Set("code", code)
+	reply()
+}
+
+// loginPage lists the users, each linking to the authorization request with a login_hint
+func (m *oidcMock) loginPage(w http.ResponseWriter, r *http.Request) {
+	type entry struct {
+		Name   string
+		Groups []string
+		URL    string
+	}
+	entries := []entry{}
+	for username, user := range oidcMockUsers {
+		q := r.URL.Query()
+		q.Set("login_hint", username)
+		entries = append(entries, entry{Name: user.Name, Groups: user.Groups, URL: "/authorize?" + q.Encode()})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	oidcMockLoginPage.Execute(w, map[string]interface{}{"Users": entries})
+}
+
+// token exchanges an authorization code for an ID token, checking the client credentials and the PKCE verifier
+func (m *oidcMock) token(w http.ResponseWriter, r *http.Request) {
+	fail := func(status int, code, description string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": code, "error_description": description})
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	if clientID != m.clientID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(m.clientSecret)) != 1 {
+		fail(http.StatusUnauthorized, "invalid_client", "unknown client or wrong secret")
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		fail(http.StatusBadRequest, "unsupported_grant_type", "")
+		return
+	}
+
+	m.mu.Lock()
+	code, ok := m.codes[r.PostFormValue("code")]
+	delete(m.codes, r.PostFormValue("code"))
+	m.mu.Unlock()
+	if !ok || time.Now().After(code.expiresAt) || code.redirectURI != r.PostFormValue("redirect_uri") {
+		fail(http.StatusBadRequest, "invalid_grant", "unknown or expired code")
+		return
+	}
+	verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(verifier[:]) != code.challenge {
+		fail(http.StatusBadRequest, "invalid_grant", "wrong code verifier")
+		return
+	}
+
+	user := oidcMockUsers[code.username]
+	now := time.Now()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":                m.issuer,
+		"sub":                user.Subject,
+		"aud":                m.clientID,
+		"azp":                m.clientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"nonce":              code.nonce,
+		"preferred_username": code.username,
+		"name":               user.Name,
+		"email":              user.Email,
+		"groups":             user.Groups,
+	})
+	idToken.Header["kid"] = "mock"
+	signed, err := idToken.SignedString(m.key)
+	if err != nil {
+		fail(http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": randomToken(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     signed,
+	})
+}
+
+func (m *oidcMock) jwks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	json.NewEncoder(w).Encode(map[string][]JWK{"keys": {{
+		Kty: "RSA",
+		Kid: "mock",
+		Use: "sig",
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+	}}})
+}
@@ -0,0 +1,264 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// pseudonymPrefix identifies pseudonymized user identifiers
+const pseudonymPrefix = "pseudo-"
+
+// erasedPrefix identifies the user identifiers of erased users
+const erasedPrefix = "erased-"
+
+// privacy hides personal data from operators without PII clearance
+type privacy struct {
+	hide bool
+}
+
+// privacyFor returns the privacy rules applying to the authenticated user
+func privacyFor(ctx context.Context) privacy {
+	return privacy{hide: conf.Privacy.Pseudonymize && !claimsFromContext(ctx).PII}
+}
+
+// userID returns the user identifier, or its pseudonym
+func (p privacy) userID(userID string) string {
+	if !p.hide || userID == "" || strings.HasPrefix(userID, erasedPrefix) {
+		return userID
+	}
+	return pseudonym(userID)
+}
+
+// email returns the email, or an empty string
+func (p privacy) email(email string) string {
+	if p.hide {
+		return ""
+	}
+	return email
+}
+
+// license returns a license with a pseudonymized user identifier and no revocation note
+func (p privacy) license(license LicenseInfo) LicenseInfo {
+	license.UserID = p.userID(license.UserID)
+	if p.hide && license.Revocation != nil {
+		revocation := *license.Revocation
+		revocation.Note = ""
+		license.Revocation = &revocation
+	}
+	return license
+}
+
+// events returns license events without device names and notes, which may name the user
+func (p privacy) events(events []Event) []Event {
+	if !p.hide {
+		return events
+	}
+	redacted := make([]Event, len(events))
+	for i, event := range events {
+		event.DeviceName = ""
+		event.Note = ""
+		redacted[i] = event
+	}
+	return redacted
+}
+
+// devices returns devices without their names, such as "John's iPad"
+func (p privacy) devices(devices []Device) []Device {
+	if !p.hide {
+		return devices
+	}
+	redacted := make([]Device, len(devices))
+	for i, device := range devices {
+		device.Name = ""
+		redacted[i] = device
+	}
+	return redacted
+}
+
+// user returns a user with a pseudonymized identifier and no email
+func (p privacy) user(user User) User {
+	user.ID = p.userID(user.ID)
+	user.Email = p.email(user.Email)
+	return user
+}
+
+// pseudonym computes a stable pseudonym of a user identifier, keyed by a server secret
+func pseudonym(userID string) string {
+	mac := hmac.New(sha256.New, conf.Privacy.PseudonymKey)
+	mac.Write([]byte(userID))
+	return pseudonymPrefix + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// loadPseudonymKey returns the pseudonym key kept next to the audit log, generating it on the first start.
+// The audit log records users by pseudonym: a new key on each start would break the links between
+// the records written before and after a restart, and leave the older pseudonyms unresolvable.
+func loadPseudonymKey(auditFile string) ([]byte, error) {
+	path := filepath.Join(filepath.Dir(auditFile), "pseudonym.key")
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if key := bytes.TrimSpace(data); len(key) > 0 {
+			return key, nil
+		}
+		return nil, fmt.Errorf("empty pseudonym key file %s", path)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	key := []byte(hex.EncodeToString(secret))
+	if err := os.WriteFile(path, append(key, '\n'), 0600); err != nil {
+		return nil, err
+	}
+	log.Printf("🔑 PSEUDONYM_KEY is not set, a pseudonym key was generated in %s: keep it with the audit log", path)
+	return key, nil
+}
+
+// resolveUserID returns the user identifier corresponding to a pseudonym,
+// so that operators without PII clearance can navigate between user views.
+// Other identifiers are returned unchanged.
+func resolveUserID(ctx context.Context, userID string) string {
+	if !strings.HasPrefix(userID, pseudonymPrefix) {
+		return userID
+	}
+	for _, rec := range store.list(ctx, nil) {
+		if pseudonym(rec.UserID) == userID {
+			return rec.UserID
+		}
+	}
+	return userID
+}
+
+// requirePII rejects the request if the user has no PII clearance
+func requirePII(w http.ResponseWriter, r *http.Request) bool {
+	if !claimsFromContext(r.Context()).PII {
+		writeProblem(w, http.StatusForbidden, "PII_CLEARANCE_REQUIRED", "this operation requires access to personal data")
+		return false
+	}
+	return true
+}
+
+// UserDataExport holds everything the server keeps about a user
+type UserDataExport struct {
+	UserID     string              `json:"user_id"`
+	Email      string              `json:"email,omitempty"`
+	ExportedAt time.Time           `json:"exported_at"`
+	ExportedBy string              `json:"exported_by"`
+	Licenses   []UserLicenseExport `json:"licenses"`
+}
+
+// UserLicenseExport is a license of a user, with its history and devices
+type UserLicenseExport struct {
+	LicenseInfo
+	Events  []Event  `json:"events"`
+	Devices []Device `json:"devices"`
+}
+
+// ExportUserData returns all the data held about a user, as a JSON attachment
+func ExportUserData(w http.ResponseWriter, r *http.Request) {
+	if !requirePII(w, r) {
+		return
+	}
+	userID := resolveUserID(r.Context(), chi.URLParam(r, "userID"))
+
+	records := store.list(r.Context(), func(rec *licenseRecord) bool { return rec.UserID == userID })
+	if len(records) == 0 {
+		writeProblem(w, http.StatusNotFound, "USER_NOT_FOUND", fmt.Sprintf("user %s not found", userID))
+		return
+	}
+
+	export := UserDataExport{
+		UserID:     userID,
+		ExportedAt: time.Now(),
+		ExportedBy: claimsFromContext(r.Context()).Username,
+		Licenses:   []UserLicenseExport{},
+	}
+	for _, rec := range records {
+		if export.Email == "" {
+			export.Email = rec.UserEmail
+		}
+		export.Licenses = append(export.Licenses, UserLicenseExport{
+			LicenseInfo: rec.LicenseInfo,
+			Events:      append([]Event{}, rec.Events...),
+			Devices:     append([]Device{}, rec.Devices...),
+		})
+	}
+
+	log.Printf("📤 Exporting personal data of user %s", userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=user-data-%s.json", export.ExportedAt.Format("20060102-150405")))
+	json.NewEncoder(w).Encode(export)
+}
+
+// EraseUserData anonymizes the personal fields of a user: identifier, email, device names and notes.
+// Licenses are kept, for statistics, under a random identifier. Operators bound to providers cannot erase,
+// as they would only reach the licenses of their providers.
+func EraseUserData(w http.ResponseWriter, r *http.Request) {
+	if !requirePII(w, r) {
+		return
+	}
+	userID := resolveUserID(r.Context(), chi.URLParam(r, "userID"))
+	operator := claimsFromContext(r.Context()).Username
+
+	if strings.HasPrefix(userID, erasedPrefix) {
+		writeProblem(w, http.StatusConflict, "USER_ALREADY_ERASED", "the user data has already been erased")
+		return
+	}
+
+	erasedID := newID(strings.TrimSuffix(erasedPrefix, "-"))
+
+	count := store.updateWhere(r.Context(), func(rec *licenseRecord) bool { return rec.UserID == userID }, func(rec *licenseRecord) {
+		rec.UserID = erasedID
+		rec.UserEmail = ""
+		for i := range rec.Events {
+			rec.Events[i].DeviceName = ""
+			rec.Events[i].Note = ""
+		}
+		for i := range rec.Devices {
+			rec.Devices[i].Name = ""
+		}
+		if rec.Revocation != nil {
+			rec.Revocation.Note = ""
+		}
+		rec.addEvent("erase", operator, "gdpr_erasure", "")
+	})
+	if count == 0 {
+		writeProblem(w, http.StatusNotFound, "USER_NOT_FOUND", fmt.Sprintf("user %s not found", userID))
+		return
+	}
+
+	log.Printf("🧹 Personal data of a user erased by %s (%d licenses)", operator, count)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"message":  "User personal data was erased",
+		"user_id":  erasedID,
+		"licenses": count,
+	})
+}
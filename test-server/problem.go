@@ -0,0 +1,62 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Problem is an RFC 7807 problem details object.
+// Code is an extension member, read by the frontend like in authentication errors.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Code   string `json:"code,omitempty"`
+}
+
+func newProblem(status int, code, detail string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// writeProblem sends an RFC 7807 problem details response
+func writeProblem(w http.ResponseWriter, status int, code, detail string) {
+	newProblem(status, code, detail).write(w)
+}
+
+// Error lets a problem be returned by the functions updating the store, such as a status checked under the lock
+func (p *Problem) Error() string {
+	return p.Detail
+}
+
+// problemFrom converts an error of a store update to a problem
+func problemFrom(err error) *Problem {
+	var p *Problem
+	switch {
+	case errors.As(err, &p):
+		return p
+	case errors.Is(err, errLicenseNotFound):
+		return newProblem(http.StatusNotFound, "LICENSE_NOT_FOUND", err.Error())
+	default:
+		return newProblem(http.StatusInternalServerError, "", err.Error())
+	}
+}
+
+func (p *Problem) write(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
@@ -0,0 +1,208 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// statusNames are the license status names used in the dashboard charts
+var statusNames = []struct{ status, name string }{
+	{StatusReady, "Ready"},
+	{StatusActive, "Active"},
+	{StatusExpired, "Expired"},
+	{StatusRevoked, "Revoked"},
+	{StatusCancelled, "Canceled"},
+	{StatusReturned, "Returned"},
+}
+
+// ProviderStats are the statistics of a content provider.
+// Licenses and the chart count the issued licenses, statuses and loans are those of the tracked licenses.
+type ProviderStats struct {
+	Provider        string           `json:"provider"`
+	Publications    int              `json:"publications"`
+	Licenses        int              `json:"licenses"`
+	LicenseStatuses []LicenseStatus  `json:"license_statuses"`
+	ActiveLoans     int              `json:"active_loans"`
+	OversharedCount int              `json:"overshared_count"`
+	ChartData       []ChartDataPoint `json:"chart_data"`
+}
+
+// monthlyChart returns the number of licenses issued during the last 12 months, oldest first
+func monthlyChart(counts map[string]int, now time.Time) []ChartDataPoint {
+	chart := make([]ChartDataPoint, 0, 12)
+	first := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -11, 0)
+	for i := 0; i < 12; i++ {
+		month := first.AddDate(0, i, 0)
+		chart = append(chart, ChartDataPoint{
+			Month:    month.Format("Jan"),
+			Licenses: counts[month.Format("2006-01")],
+		})
+	}
+	return chart
+}
+
+// licenseStatuses counts licenses per status
+func licenseStatuses(records []licenseRecord) []LicenseStatus {
+	counts := map[string]int{}
+	for _, rec := range records {
+		counts[rec.Status]++
+	}
+	statuses := make([]LicenseStatus, 0, len(statusNames))
+	for _, s := range statusNames {
+		statuses = append(statuses, LicenseStatus{Name: s.name, Count: counts[s.status]})
+	}
+	return statuses
+}
+
+// providerStats computes the statistics of every provider
+func providerStats(ctx context.Context, now time.Time) map[string]*ProviderStats {
+	stats := map[string]*ProviderStats{}
+	get := func(provider string) *ProviderStats {
+		s, ok := stats[provider]
+		if !ok {
+			s = &ProviderStats{Provider: provider}
+			stats[provider] = s
+		}
+		return s
+	}
+
+	publications := map[string]map[string]bool{}
+	months := map[string]map[string]int{}
+	issuedLicenses(ctx, func(day issuanceDay) {
+		s := get(day.Provider)
+		s.Licenses += day.Licenses
+		if publications[day.Provider] == nil {
+			publications[day.Provider] = map[string]bool{}
+			months[day.Provider] = map[string]int{}
+		}
+		publications[day.Provider][day.PublicationID] = true
+		months[day.Provider][day.Date.UTC().Format("2006-01")] += day.Licenses
+	})
+
+	records := map[string][]licenseRecord{}
+	for _, rec := range store.list(ctx, nil) {
+		provider := rec.provider()
+		records[provider] = append(records[provider], rec)
+		s := get(provider)
+		if rec.Type == "loan" && rec.Status == StatusActive {
+			s.ActiveLoans++
+		}
+		if rec.DeviceCount >= oversharedDeviceThreshold {
+			s.OversharedCount++
+		}
+	}
+
+	// licenses without provider are not attributed
+	delete(stats, "")
+	for provider, s := range stats {
+		s.Publications = len(publications[provider])
+		s.LicenseStatuses = licenseStatuses(records[provider])
+		s.ChartData = monthlyChart(months[provider], now)
+	}
+	return stats
+}
+
+// Providers returns the statistics of every content provider
+func Providers(w http.ResponseWriter, r *http.Request) {
+	stats := providerStats(r.Context(), time.Now())
+
+	list := make([]*ProviderStats, 0, len(stats))
+	for _, s := range stats {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Provider < list[j].Provider })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// ProviderDetail returns the statistics of a content provider
+func ProviderDetail(w http.ResponseWriter, r *http.Request) {
+	provider := chi.URLParam(r, "provider")
+
+	s, ok := providerStats(r.Context(), time.Now())[provider]
+	if !ok {
+		writeProblem(w, http.StatusNotFound, "PROVIDER_NOT_FOUND", fmt.Sprintf("provider %s not found", provider))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+// providerDashboard computes the dashboard data of a provider, or of every provider visible
+// to the operator if provider is empty. It returns nil if the provider is unknown.
+func providerDashboard(ctx context.Context, provider string, now time.Time) *DashboardData {
+	data := &DashboardData{}
+	publications := map[string]bool{}
+	months := map[string]int{}
+	var oldest, latest time.Time
+
+	issuedLicenses(ctx, func(day issuanceDay) {
+		if (provider != "" && day.Provider != provider) || day.Licenses == 0 {
+			return
+		}
+		publications[day.PublicationID] = true
+		months[day.Date.UTC().Format("2006-01")] += day.Licenses
+		data.TotalLicenses += day.Licenses
+
+		age := now.Sub(day.Date)
+		switch {
+		case age <= 24*time.Hour:
+			data.LicensesLastDay += day.Licenses
+			fallthrough
+		case age <= 7*24*time.Hour:
+			data.LicensesLastWeek += day.Licenses
+			fallthrough
+		case day.Date.After(now.AddDate(0, -1, 0)):
+			data.LicensesLastMonth += day.Licenses
+			fallthrough
+		case day.Date.After(now.AddDate(-1, 0, 0)):
+			data.LicensesLast12Months += day.Licenses
+		}
+		if oldest.IsZero() || day.Date.Before(oldest) {
+			oldest = day.Date
+		}
+		if day.Date.After(latest) {
+			latest = day.Date
+		}
+	})
+	if len(publications) == 0 {
+		return nil
+	}
+
+	records := store.list(ctx, func(rec *licenseRecord) bool { return provider == "" || rec.provider() == provider })
+	users := map[string]bool{}
+	contentTypes := map[string]int{}
+	for _, rec := range records {
+		users[rec.UserID] = true
+		contentTypes[rec.contentType()]++
+		if rec.DeviceCount >= oversharedDeviceThreshold {
+			data.OversharedLicensesCount++
+		}
+	}
+
+	data.TotalPublications = len(publications)
+	data.TotalUsers = len(users)
+	data.OldestLicenseDate = oldest.Format("2006-01-02")
+	data.LatestLicenseDate = latest.Format("2006-01-02")
+	data.PublicationTypes = []PublicationType{
+		{Name: "EPUB", Count: contentTypes[contentTypeEPUB]},
+		{Name: "PDF", Count: contentTypes[contentTypePDF]},
+	}
+	data.LicenseStatuses = licenseStatuses(records)
+	data.ChartData = monthlyChart(months, now)
+	return data
+}
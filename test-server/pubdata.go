@@ -7,8 +7,10 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"slices"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -35,7 +37,39 @@ func Publications(w http.ResponseWriter, r *http.Request) {
 
 	page := r.Context().Value(PageKey).(int)
 	perPage := r.Context().Value(PerPageKey).(int)
-	publications := []Publication{
+	publications := publicationsFor(r.Context())
+
+	// Simple pagination logic
+	start := (page - 1) * perPage
+	end := start + perPage
+	if start > len(publications) {
+		start = len(publications)
+	}
+	if end > len(publications) {
+		end = len(publications)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(publications[start:end])
+}
+
+// publicationsFor returns the publications of the providers visible to the operator
+func publicationsFor(ctx context.Context) []Publication {
+	return scoped(ctx, publicationCatalog(), func(pub Publication) string { return pub.Provider })
+}
+
+// ownsPublication tells if the operator can change a publication. Operators bound to no provider
+// can change any publication, including the publications the test server does not list.
+func ownsPublication(ctx context.Context, uuid string) bool {
+	if !tenantFor(ctx).restricted() {
+		return true
+	}
+	return slices.ContainsFunc(publicationsFor(ctx), func(pub Publication) bool { return pub.UUID == uuid })
+}
+
+// publicationCatalog returns the publications of the test server
+func publicationCatalog() []Publication {
+	return []Publication{
 		{
 			CreatedAt:   time.Now().AddDate(0, 0, -10),
 			Provider:    "Provider A",
@@ -673,19 +707,6 @@ func Publications(w http.ResponseWriter, r *http.Request) {
 			Checksum:    "tuv456checksum",
 		},
 	}
-
-	// Simple pagination logic
-	start := (page - 1) * perPage
-	end := start + perPage
-	if start > len(publications) {
-		start = len(publications)
-	}
-	if end > len(publications) {
-		end = len(publications)
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(publications[start:end])
 }
 
 var deleteCounter int
@@ -704,6 +725,11 @@ func DeletePublication(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
+	if !ownsPublication(r.Context(), uuid) {
+		writeProblem(w, http.StatusNotFound, "PUBLICATION_NOT_FOUND", "publication "+uuid+" not found")
+		return
+	}
+
 	// Alternate between success (200) and error (400)
 	deleteCounter++
 	if deleteCounter%2 == 0 {
@@ -719,6 +745,11 @@ func DeletePublication(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	// Return success on odd calls
+	webhooks.dispatch(WebhookPublicationDeleted, map[string]string{
+		"uuid":     uuid,
+		"operator": claimsFromContext(r.Context()).Username,
+	})
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{
@@ -0,0 +1,108 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+)
+
+// Operator roles. Every role can read the dashboard data; the permissions below are granted on top of it.
+const (
+	RoleViewer             = "viewer"
+	RoleSupport            = "support"
+	RolePublicationManager = "publication_manager"
+	RoleAdmin              = "admin"
+)
+
+var roles = []string{RoleViewer, RoleSupport, RolePublicationManager, RoleAdmin}
+
+// Permission is the right to perform a group of mutating operations
+type Permission string
+
+const (
+	// PermManageLicenses covers revocations, renewals, returns and device deregistrations
+	PermManageLicenses Permission = "licenses:write"
+	// PermManageUserData covers the export and erasure of the personal data of a user
+	PermManageUserData Permission = "users:write"
+	// PermManagePublications covers the deletion of publications
+	PermManagePublications Permission = "publications:write"
+	// PermManageSettings covers webhooks and alerts
+	PermManageSettings Permission = "settings:write"
+	// PermManageAccounts covers the operator accounts
+	PermManageAccounts Permission = "accounts:write"
+	// PermReadAudit covers the queries and exports of the audit log
+	PermReadAudit Permission = "audit:read"
+)
+
+// rolePermissions are the permissions granted to each role
+var rolePermissions = map[string][]Permission{
+	RoleViewer:             {},
+	RoleSupport:            {PermManageLicenses, PermManageUserData},
+	RolePublicationManager: {PermManagePublications},
+	RoleAdmin:              {PermManageLicenses, PermManageUserData, PermManagePublications, PermManageSettings, PermManageAccounts, PermReadAudit},
+}
+
+// hasPermission tells if a role is granted a permission
+func hasPermission(role string, p Permission) bool {
+	return slices.Contains(rolePermissions[role], p)
+}
+
+// requirePermission restricts a route to the operators whose role is granted a permission
+func requirePermission(p Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := claimsFromContext(r.Context())
+			if claims.APIKey != "" {
+				if !slices.Contains(claims.Scopes, string(p)) {
+					writeProblem(w, http.StatusForbidden, "SCOPE_DENIED", fmt.Sprintf("the API key does not have the %s scope", p))
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !hasPermission(claims.Role, p) {
+				writeProblem(w, http.StatusForbidden, "PERMISSION_DENIED", fmt.Sprintf("the %s role does not grant the %s permission", claims.Role, p))
+				return
+			}
+			if slices.Contains(conf.TwoFactor.RequiredFor, p) && !claims.MFA {
+				writeProblem(w, http.StatusForbidden, "TWO_FACTOR_REQUIRED", fmt.Sprintf("the %s permission requires a login confirmed by a second factor", p))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// permissionList converts configured permission names, ignoring the unknown ones
+func permissionList(names []string) []Permission {
+	list := []Permission{}
+	for _, name := range names {
+		p := Permission(name)
+		if !slices.Contains(rolePermissions[RoleAdmin], p) {
+			log.Printf("Unknown permission %q ignored", name)
+			continue
+		}
+		list = append(list, p)
+	}
+	return list
+}
+
+// roleList converts configured role names, ignoring the unknown ones
+func roleList(names []string) []string {
+	list := []string{}
+	for _, name := range names {
+		if !slices.Contains(roles, name) {
+			log.Printf("Unknown role %q ignored", name)
+			continue
+		}
+		list = append(list, name)
+	}
+	return list
+}
@@ -0,0 +1,62 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// checkPermission sends a request authenticated with the given claims to a route requiring a permission
+func checkPermission(claims *Claims, permission Permission) *httptest.ResponseRecorder {
+	h := requirePermission(permission)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest(http.MethodPost, "/", nil).WithContext(contextWithClaims(claims))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// problemCode returns the code of the problem written in a response
+func problemCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var p Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatalf("the response is not a problem: %s", w.Body.String())
+	}
+	return p.Code
+}
+
+func TestRequirePermission(t *testing.T) {
+	if w := checkPermission(&Claims{Role: RoleSupport}, PermManageLicenses); w.Code != http.StatusOK {
+		t.Errorf("support revoking a license: status %d", w.Code)
+	}
+	if w := checkPermission(&Claims{Role: RolePublicationManager}, PermManagePublications); w.Code != http.StatusOK {
+		t.Errorf("publication manager deleting a publication: status %d", w.Code)
+	}
+	if w := checkPermission(&Claims{Role: RoleAdmin}, PermReadAudit); w.Code != http.StatusOK {
+		t.Errorf("admin reading the audit log: status %d", w.Code)
+	}
+
+	denied := []struct {
+		role       string
+		permission Permission
+	}{
+		{RoleViewer, PermManageLicenses},
+		{RoleSupport, PermManagePublications},
+		{RolePublicationManager, PermManageLicenses},
+		{RoleSupport, PermManageAccounts},
+		{"root", PermManageLicenses},
+	}
+	for _, d := range denied {
+		w := checkPermission(&Claims{Role: d.role}, d.permission)
+		if w.Code != http.StatusForbidden || problemCode(t, w) != "PERMISSION_DENIED" {
+			t.Errorf("%s with %s: status %d, %s", d.role, d.permission, w.Code, w.Body.String())
+		}
+	}
+}
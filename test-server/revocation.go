@@ -0,0 +1,385 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Revocation reason codes
+const (
+	ReasonOvershare        = "overshare"
+	ReasonFraud            = "fraud"
+	ReasonUserRequest      = "user_request"
+	ReasonPublisherRequest = "publisher_request"
+)
+
+var revocationReasons = []string{ReasonOvershare, ReasonFraud, ReasonUserRequest, ReasonPublisherRequest}
+
+// maxNoteLength limits the size of the free-text note attached to a revocation
+const maxNoteLength = 2000
+
+// Revocation documents why and by whom a license was revoked.
+type Revocation struct {
+	Reason         string     `json:"reason"`
+	Note           string     `json:"note,omitempty"`
+	Operator       string     `json:"operator"`
+	RevokedAt      time.Time  `json:"revoked_at"`
+	PreviousStatus string     `json:"previous_status"`
+	UndoUntil      time.Time  `json:"undo_until"`
+	ReinstatedBy   string     `json:"reinstated_by,omitempty"`
+	ReinstatedAt   *time.Time `json:"reinstated_at,omitempty"`
+}
+
+// RevokeRequest is the body of a revocation request
+type RevokeRequest struct {
+	Reason string `json:"reason"`
+	Note   string `json:"note"`
+}
+
+// validate checks the reason code and the note of a revocation request
+func (req *RevokeRequest) validate() error {
+	req.Note = strings.TrimSpace(req.Note)
+	if len(req.Note) > maxNoteLength {
+		return fmt.Errorf("note must not exceed %d characters", maxNoteLength)
+	}
+	for _, reason := range revocationReasons {
+		if req.Reason == reason {
+			return nil
+		}
+	}
+	if req.Reason == "" {
+		return fmt.Errorf("reason is required, one of %s", strings.Join(revocationReasons, ", "))
+	}
+	return fmt.Errorf("unknown reason %q, expected one of %s", req.Reason, strings.Join(revocationReasons, ", "))
+}
+
+func RevokeLicense(w http.ResponseWriter, r *http.Request) {
+	licenseID := chi.URLParam(r, "licenseID")
+	operator := claimsFromContext(r.Context()).Username
+
+	var req RevokeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, "INVALID_BODY", "a JSON body with a reason and an optional note is required")
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeProblem(w, http.StatusBadRequest, "INVALID_REASON", err.Error())
+		return
+	}
+
+	revocation, problem := revoke(r.Context(), licenseID, operator, req)
+	if problem != nil {
+		problem.write(w)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":    true,
+		"message":    "License revocation was successful",
+		"licenseID":  licenseID,
+		"status":     StatusRevoked,
+		"revocation": revocation,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// revoke revokes a license through the LSD server, then records the revocation locally.
+// It returns a problem if the license cannot be revoked.
+func revoke(ctx context.Context, licenseID, operator string, req RevokeRequest) (*Revocation, *Problem) {
+	log.Printf("🔄 Revoking license: %s (%s)", licenseID, req.Reason)
+
+	rec, ok := store.get(ctx, licenseID)
+	if !ok {
+		return nil, newProblem(http.StatusNotFound, "LICENSE_NOT_FOUND", fmt.Sprintf("license %s not found", licenseID))
+	}
+	if code, detail := checkRevocable(rec.Status); code != "" {
+		return nil, newProblem(http.StatusConflict, code, detail)
+	}
+
+	// The LSD server is the reference for the license status; update it first
+	// Only the reason code is sent, as the status document is visible to the user
+	if err := lsd.UpdateStatus(ctx, licenseID, StatusRevoked, "revoked: "+req.Reason); err != nil {
+		log.Printf("LSD revocation of %s failed: %v", licenseID, err)
+		return nil, newProblem(http.StatusBadGateway, "LSD_ERROR", "the license status server refused the revocation: "+err.Error())
+	}
+
+	// The status is checked again under the lock, as a concurrent request may have revoked the license
+	var revocation Revocation
+	err := store.update(ctx, licenseID, func(rec *licenseRecord) error {
+		if code, detail := checkRevocable(rec.Status); code != "" {
+			return newProblem(http.StatusConflict, code, detail)
+		}
+		now := time.Now()
+		revocation = Revocation{
+			Reason:         req.Reason,
+			Note:           req.Note,
+			Operator:       operator,
+			RevokedAt:      now,
+			PreviousStatus: rec.Status,
+			UndoUntil:      now.Add(conf.RevocationUndoWindow),
+		}
+		rec.Status = StatusRevoked
+		stored := revocation
+		rec.Revocation = &stored
+		rec.addEvent("revoke", operator, req.Reason, req.Note)
+		return nil
+	})
+	if err != nil {
+		return nil, problemFrom(err)
+	}
+	return &revocation, nil
+}
+
+// maxBatchSize limits the number of licenses revoked by a single batch request
+const maxBatchSize = 500
+
+// RevokeFilter selects the licenses of a batch revocation.
+// Only ready and active licenses are selected.
+type RevokeFilter struct {
+	MinDevices    int    `json:"min_devices,omitempty"`
+	PublicationID string `json:"publication_id,omitempty"`
+	Provider      string `json:"provider,omitempty"`
+}
+
+func (f *RevokeFilter) empty() bool {
+	return f.MinDevices <= 0 && f.PublicationID == "" && f.Provider == ""
+}
+
+func (f *RevokeFilter) match(rec *licenseRecord) bool {
+	if rec.Status != StatusReady && rec.Status != StatusActive {
+		return false
+	}
+	if f.MinDevices > 0 && rec.DeviceCount < f.MinDevices {
+		return false
+	}
+	if f.PublicationID != "" && rec.PublicationID != f.PublicationID {
+		return false
+	}
+	if f.Provider != "" && (rec.Provider == nil || *rec.Provider != f.Provider) {
+		return false
+	}
+	return true
+}
+
+// BatchRevokeRequest is the body of a batch revocation request.
+// Either LicenseIDs or Filter must be set.
+type BatchRevokeRequest struct {
+	RevokeRequest
+	LicenseIDs []string      `json:"license_ids,omitempty"`
+	Filter     *RevokeFilter `json:"filter,omitempty"`
+	DryRun     bool          `json:"dry_run"`
+}
+
+// BatchRevokeResult is the outcome of the revocation of one license of a batch
+type BatchRevokeResult struct {
+	LicenseID string   `json:"license_id"`
+	Success   bool     `json:"success"`
+	Status    string   `json:"status,omitempty"`
+	Error     *Problem `json:"error,omitempty"`
+}
+
+// RevokeLicenses revokes a list of licenses, or the licenses matching a filter.
+// With dry_run, it only returns the licenses which would be revoked.
+func RevokeLicenses(w http.ResponseWriter, r *http.Request) {
+	operator := claimsFromContext(r.Context()).Username
+
+	var req BatchRevokeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, "INVALID_BODY", "invalid batch revocation request")
+		return
+	}
+	if (len(req.LicenseIDs) == 0) == (req.Filter == nil) {
+		writeProblem(w, http.StatusBadRequest, "INVALID_SELECTION", "either license_ids or filter must be set")
+		return
+	}
+	if req.Filter != nil && req.Filter.empty() {
+		writeProblem(w, http.StatusBadRequest, "INVALID_SELECTION", "the filter must have at least one criterion")
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeProblem(w, http.StatusBadRequest, "INVALID_REASON", err.Error())
+		return
+	}
+
+	licenseIDs := req.LicenseIDs
+	if req.Filter != nil {
+		licenseIDs = nil
+		for _, rec := range store.list(r.Context(), req.Filter.match) {
+			licenseIDs = append(licenseIDs, rec.UUID)
+		}
+	}
+	licenseIDs = uniqueStrings(licenseIDs)
+	if len(licenseIDs) > maxBatchSize {
+		writeProblem(w, http.StatusRequestEntityTooLarge, "BATCH_TOO_LARGE",
+			fmt.Sprintf("%d licenses selected, a batch is limited to %d", len(licenseIDs), maxBatchSize))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if req.DryRun {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"dry_run":     true,
+			"count":       len(licenseIDs),
+			"license_ids": licenseIDs,
+		})
+		return
+	}
+
+	log.Printf("🔄 Batch revocation of %d licenses by %s", len(licenseIDs), operator)
+
+	results := make([]BatchRevokeResult, 0, len(licenseIDs))
+	revoked := 0
+	for _, licenseID := range licenseIDs {
+		result := BatchRevokeResult{LicenseID: licenseID}
+		if _, problem := revoke(r.Context(), licenseID, operator, req.RevokeRequest); problem != nil {
+			result.Error = problem
+		} else {
+			result.Success = true
+			result.Status = StatusRevoked
+			revoked++
+		}
+		results = append(results, result)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"dry_run": false,
+		"count":   len(licenseIDs),
+		"revoked": revoked,
+		"failed":  len(licenseIDs) - revoked,
+		"results": results,
+	})
+}
+
+// uniqueStrings removes empty and duplicate values, keeping the order
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	unique := []string{}
+	for _, v := range values {
+		if v != "" && !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+// ReinstateRequest is the optional body of a reinstatement request
+type ReinstateRequest struct {
+	Note string `json:"note"`
+}
+
+// validate checks the note of a reinstatement request
+func (req *ReinstateRequest) validate() error {
+	req.Note = strings.TrimSpace(req.Note)
+	if len(req.Note) > maxNoteLength {
+		return fmt.Errorf("note must not exceed %d characters", maxNoteLength)
+	}
+	return nil
+}
+
+// ReinstateLicense cancels a revocation made by mistake, during the undo window
+func ReinstateLicense(w http.ResponseWriter, r *http.Request) {
+	licenseID := chi.URLParam(r, "licenseID")
+	operator := claimsFromContext(r.Context()).Username
+
+	var req ReinstateRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeProblem(w, http.StatusBadRequest, "INVALID_BODY", "invalid reinstatement request")
+			return
+		}
+	}
+	if err := req.validate(); err != nil {
+		writeProblem(w, http.StatusBadRequest, "INVALID_NOTE", err.Error())
+		return
+	}
+
+	rec, ok := store.get(r.Context(), licenseID)
+	if !ok {
+		writeProblem(w, http.StatusNotFound, "LICENSE_NOT_FOUND", fmt.Sprintf("license %s not found", licenseID))
+		return
+	}
+	if problem := checkReinstatable(&rec, time.Now()); problem != nil {
+		problem.write(w)
+		return
+	}
+
+	log.Printf("↩️ Reinstating license: %s", licenseID)
+
+	previous := rec.Revocation.PreviousStatus
+	if err := lsd.UpdateStatus(r.Context(), licenseID, previous, "revocation cancelled"); err != nil {
+		log.Printf("LSD reinstatement of %s failed: %v", licenseID, err)
+		writeProblem(w, http.StatusBadGateway, "LSD_ERROR", "the license status server refused the reinstatement: "+err.Error())
+		return
+	}
+
+	// The revocation is checked again under the lock, as a concurrent request may have reinstated
+	// the license, and the undo window may have closed during the LSD call
+	err := store.update(r.Context(), licenseID, func(rec *licenseRecord) error {
+		now := time.Now()
+		if problem := checkReinstatable(rec, now); problem != nil {
+			return problem
+		}
+		rec.Status = previous
+		rec.Revocation.ReinstatedBy = operator
+		rec.Revocation.ReinstatedAt = &now
+		rec.addEvent("reinstate", operator, rec.Revocation.Reason, req.Note)
+		return nil
+	})
+	if err != nil {
+		problemFrom(err).write(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"message":   "License reinstatement was successful",
+		"licenseID": licenseID,
+		"status":    previous,
+	})
+}
+
+// checkReinstatable returns a problem if the revocation of a license cannot be undone at the given time
+func checkReinstatable(rec *licenseRecord, now time.Time) *Problem {
+	if rec.Status != StatusRevoked || rec.Revocation == nil || rec.Revocation.ReinstatedAt != nil {
+		return newProblem(http.StatusConflict, "LICENSE_NOT_REVOKED", "the license is not revoked")
+	}
+	if now.After(rec.Revocation.UndoUntil) {
+		return newProblem(http.StatusConflict, "UNDO_WINDOW_EXPIRED",
+			fmt.Sprintf("the revocation can only be undone until %s", rec.Revocation.UndoUntil.Format(time.RFC3339)))
+	}
+	return nil
+}
+
+// checkRevocable returns a problem code and detail if a license in the given status cannot be revoked
+func checkRevocable(status string) (code, detail string) {
+	switch status {
+	case StatusRevoked:
+		return "LICENSE_ALREADY_REVOKED", "the license is already revoked"
+	case StatusReturned:
+		return "LICENSE_RETURNED", "the license has been returned"
+	case StatusExpired:
+		return "LICENSE_EXPIRED", "the license has expired"
+	case StatusCancelled:
+		return "LICENSE_CANCELLED", "the license has been cancelled"
+	}
+	return "", ""
+}
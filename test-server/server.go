@@ -12,7 +12,11 @@ import (
 	"errors"
 	"log"
 	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -21,8 +25,6 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtKey = []byte("votre_cle_secrete")
-
 type Credentials struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
@@ -30,10 +32,93 @@ type Credentials struct {
 
 type Claims struct {
 	Username string `json:"username"`
+	// PII tells if the user has access to personal data
+	PII bool `json:"pii,omitempty"`
+	// Role is the role of the user, granting permissions
+	Role string `json:"role"`
+	// Providers are the providers the user is bound to, none for all providers
+	Providers []string `json:"providers,omitempty"`
+	// SessionID is the login session of the token, closed by a logout
+	SessionID string `json:"sid,omitempty"`
+	// MFA tells if the login was confirmed by a second factor
+	MFA bool `json:"mfa,omitempty"`
+	// APIKey is the key authenticating a machine client, whose Scopes replace the permissions of a role.
+	// They are never part of an access token.
+	APIKey string   `json:"-"`
+	Scopes []string `json:"-"`
 	jwt.RegisteredClaims
 }
 
+// conf is the server configuration, loaded in main
+var conf Config
+
 func main() {
+	conf = loadConfig()
+
+	// "verify-audit" checks the audit log and exits
+	if len(os.Args) > 1 && os.Args[1] == "verify-audit" {
+		verifyAuditCommand()
+		return
+	}
+
+	if err := accounts.load(conf.Accounts); err != nil {
+		log.Fatal("Error loading the accounts:", err)
+	}
+	if err := apiKeys.load(conf.APIKeysFile); err != nil {
+		log.Fatal("Error loading the API keys:", err)
+	}
+	if err := jwtKeys.load(conf.Keys); err != nil {
+		log.Fatal("Error loading the keys:", err)
+	}
+	// checkpoints never expire, their keys must not be accepted for access tokens
+	if conf.Audit.Keys.Dir != "" && filepath.Clean(conf.Audit.Keys.Dir) == filepath.Clean(conf.Keys.Dir) {
+		log.Fatal("AUDIT_KEYS_DIR must not be the directory of the access token keys")
+	}
+	if err := auditKeys.load(conf.Audit.Keys); err != nil {
+		log.Fatal("Error loading the audit keys:", err)
+	}
+	if err := audit.open(conf.Audit); err != nil {
+		log.Fatal("Error opening the audit log:", err)
+	}
+	if len(conf.Privacy.PseudonymKey) == 0 {
+		key, err := loadPseudonymKey(conf.Audit.File)
+		if err != nil {
+			log.Fatal("Error loading the pseudonym key:", err)
+		}
+		conf.Privacy.PseudonymKey = key
+	}
+	if v, err := audit.verify(); err == nil && !v.Valid {
+		log.Printf("🚨 The audit log was altered: %d problems, see /dashdata/audit/verify", len(v.Problems))
+	}
+	if conf.Audit.CheckpointInterval > 0 {
+		go audit.checkpointEvery(conf.Audit.CheckpointInterval)
+	}
+	go reloadKeysOnSignal()
+
+	// Use a local stand-in of the LSD server if none is configured
+	if conf.LSD.BaseURL == "" {
+		stub := newLSDStub(conf.LSD)
+		activities.listen(stub.onActivity)
+		stub.start(conf.LSD.StubAddr)
+		conf.LSD.BaseURL = "http://" + conf.LSD.StubAddr
+	}
+	lsd = newLSDClient(conf.LSD)
+
+	// Use a local stand-in of an OIDC provider to test single sign-on
+	if conf.OIDC.Mock {
+		newOIDCMock(conf.OIDC).start(conf.OIDC.MockAddr)
+		if conf.OIDC.Issuer == "" {
+			conf.OIDC.Issuer = "http://" + conf.OIDC.MockAddr
+		}
+	}
+
+	activities.listen(webhooks.onActivity)
+	go alerts.run(conf.AlertInterval)
+
+	if conf.SimulateActivity > 0 {
+		go simulateActivity(conf.SimulateActivity)
+	}
+
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
 
@@ -53,27 +138,122 @@ func main() {
 		AllowedOrigins:   []string{"http://localhost:8090", "http://localhost:4173"}, // URLs React frontend
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		ExposedHeaders:   []string{"Link", "Retry-After"},
 		AllowCredentials: true,
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
 	}))
 
+	// Record the operator actions, who did what
+	r.Use(auditMiddleware)
+
+	r.Get("/dashdata/.well-known/jwks.json", JWKS)
 	r.Post("/dashdata/login", login)
+	r.Post("/dashdata/login/2fa", LoginSecondFactor)
+	r.Post("/dashdata/refresh", Refresh)
+	r.Post("/dashdata/logout", Logout)
+	r.Get("/dashdata/oidc", OIDCStatus)
+	r.Get("/dashdata/oidc/login", OIDCLogin)
+	r.Get("/dashdata/oidc/callback", OIDCCallback)
+	r.Group(func(r chi.Router) {
+		r.Use(ingestAuth)
+		r.Post("/dashdata/events", IngestEvent)
+		r.Post("/dashdata/events/batch", IngestEvents)
+	})
 	r.Group(func(r chi.Router) {
 		r.Use(authMiddleware)
 		r.Get("/dashdata/data", Dashboard)
 		r.Get("/dashdata/report-licenses", ReportLicenses)
 		r.Get("/dashdata/overshared", OversharedLicenses)
-		r.Put("/dashdata/revoke/{licenseID}", RevokeLicense)
 		r.Get("/dashdata/user-licenses/{userID}", UserLicenses)
+		r.Get("/dashdata/users/{userID}", UserDetail)
 		r.Get("/dashdata/license-events/{licenseID}", LicenseEvents)
+		r.Get("/dashdata/license-devices/{licenseID}", LicenseDevices)
+		r.Get("/dashdata/stream", Stream)
+		r.Get("/dashdata/providers", Providers)
+		r.Get("/dashdata/providers/{provider}", ProviderDetail)
+		r.Get("/dashdata/anomalies", Anomalies)
+		r.Get("/dashdata/analytics/publications", PublicationsAnalytics)
+
+		// every operator manages their own second factor
+		r.Get("/dashdata/2fa", TwoFactorStatus)
+		r.Post("/dashdata/2fa/enroll", EnrollTwoFactor)
+		r.Post("/dashdata/2fa/confirm", ConfirmTwoFactor)
+		r.Post("/dashdata/2fa/recovery-codes", RegenerateRecoveryCodes)
+		r.Post("/dashdata/2fa/disable", DisableTwoFactor)
+
+		r.Group(func(r chi.Router) {
+			r.Use(requirePermission(PermManageLicenses))
+			r.Put("/dashdata/revoke/{licenseID}", RevokeLicense)
+			r.Put("/dashdata/reinstate/{licenseID}", ReinstateLicense)
+			r.Post("/dashdata/revoke-batch", RevokeLicenses)
+			r.Put("/dashdata/renew/{licenseID}", RenewLicense)
+			r.Put("/dashdata/return/{licenseID}", ReturnLicense)
+			r.Delete("/dashdata/license-devices/{licenseID}/{deviceID}", DeregisterDevice)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(requirePermission(PermManageUserData))
+			r.Get("/dashdata/users/{userID}/export", ExportUserData)
+			// an erasure must cover every license of the data subject, not only those of some providers
+			r.With(requireAllProviders).Post("/dashdata/users/{userID}/erase", EraseUserData)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(requirePermission(PermManageAccounts))
+			// accounts are not bound to providers: an administrator bound to providers could
+			// otherwise create an unrestricted account, or remove their own restriction
+			r.Group(func(r chi.Router) {
+				r.Use(requireAllProviders)
+				r.Get("/dashdata/accounts", Accounts)
+				r.Post("/dashdata/accounts", CreateAccount)
+				r.Get("/dashdata/accounts/{username}", AccountDetail)
+				r.Put("/dashdata/accounts/{username}", UpdateAccount)
+				r.Delete("/dashdata/accounts/{username}", DeleteAccount)
+				r.Delete("/dashdata/accounts/{username}/sessions", RevokeAccountSessions)
+				r.Delete("/dashdata/accounts/{username}/2fa", ResetTwoFactor)
+				r.Delete("/dashdata/accounts/{username}/lockout", UnlockAccount)
+				r.Get("/dashdata/login-failures", FailedLogins)
+			})
+			r.Get("/dashdata/api-keys", APIKeys)
+			r.Post("/dashdata/api-keys", CreateAPIKey)
+			r.Get("/dashdata/api-keys/{keyID}", APIKeyDetail)
+			r.Delete("/dashdata/api-keys/{keyID}", RevokeAPIKey)
+		})
+
+		// webhooks and alerts apply to every provider
+		r.Group(func(r chi.Router) {
+			r.Use(requirePermission(PermManageSettings))
+			r.Use(requireAllProviders)
+			r.Get("/dashdata/webhooks", Webhooks)
+			r.Post("/dashdata/webhooks", CreateWebhook)
+			r.Delete("/dashdata/webhooks/{webhookID}", DeleteWebhook)
+			r.Get("/dashdata/webhooks/{webhookID}/deliveries", WebhookDeliveries)
+			r.Post("/dashdata/webhooks/deliveries/{deliveryID}/replay", ReplayWebhookDelivery)
+			r.Get("/dashdata/alerts", Alerts)
+			r.Post("/dashdata/alerts", CreateAlert)
+			r.Get("/dashdata/alerts/history", AlertHistory)
+			r.Get("/dashdata/alerts/metrics", AlertMetrics)
+			r.Delete("/dashdata/alerts/{alertID}", DeleteAlert)
+			r.Post("/dashdata/alerts/{alertID}/silence", SilenceAlert)
+		})
 	})
 
 	r.Group(func(r chi.Router) {
 		r.Use(authMiddleware)
 		r.Use(paginate)
 		r.Get("/dashdata/publications", Publications)
-		r.Delete("/dashdata/publications/{uuid}", DeletePublication)
+		r.Get("/dashdata/users", Users)
+		r.With(requirePermission(PermManagePublications)).Delete("/dashdata/publications/{uuid}", DeletePublication)
+		r.Group(func(r chi.Router) {
+			r.Use(requirePermission(PermReadAudit))
+			r.Use(requireAllProviders)
+			r.Get("/dashdata/audit", AuditLog)
+			r.Get("/dashdata/audit/export", ExportAuditLog)
+			r.Get("/dashdata/audit/verify", VerifyAuditLog)
+			r.Get("/dashdata/audit/checkpoints", AuditCheckpoints)
+			r.Post("/dashdata/audit/checkpoints", CreateAuditCheckpoint)
+			r.Get("/dashdata/audit/keys", AuditKeys)
+		})
 	})
 
 	// Start the server on port 8989
@@ -93,31 +273,91 @@ func login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check credentials (simplified example)
-	if creds.Username != "admin" || creds.Password != "supersecret" {
+	auditOperator(r, creds.Username)
+	ip := clientIP(r)
+	if wait := loginLimits.retryAfter(creds.Username, ip); wait > 0 {
+		refuseLogin(w, creds.Username, ip, wait)
+		return
+	}
+
+	account, err := accounts.authenticate(creds.Username, creds.Password)
+	if err != nil {
+		log.Printf("🔐 Login refused for %q: %v", creds.Username, err)
+		if errors.Is(err, errInvalidCredentials) {
+			loginLimits.recordFailure(creds.Username, ip, "invalid_credentials")
+		}
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
 	log.Println("🔐 User:", creds.Username)
 
-	// Create JWT token
-	//expirationTime := time.Now().Add(30 * time.Second) // 30 seconds for testing
-	expirationTime := time.Now().Add(1 * time.Hour) // 1 hour for production
+	// The access token of an account with two-factor authentication is only issued
+	// once a code is sent to /dashdata/login/2fa, which also counts the failures
+	if account.TwoFactor {
+		startSecondFactor(w, account)
+		return
+	}
+	loginLimits.recordSuccess(account.Username)
+
+	sess, refreshToken, err := sessions.create(account.Username, false)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeTokens(w, account, sess, refreshToken)
+}
+
+// issueToken creates the access token of a session
+func issueToken(account Account, sess session) (string, time.Time, error) {
+	expirationTime := time.Now().Add(conf.Tokens.AccessTTL)
 	claims := &Claims{
-		Username: creds.Username,
+		Username:  account.Username,
+		Role:      account.Role,
+		PII:       slices.Contains(conf.Privacy.ClearedUsers, account.Username),
+		Providers: account.Providers,
+		SessionID: sess.ID,
+		MFA:       sess.MFA,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        newID("at"),
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
+	tokenString, err := jwtKeys.sign(claims)
+	return tokenString, expirationTime, err
+}
+
+// writeTokens sends a new access token and a refresh token, as cookies and in the JSON response
+func writeTokens(w http.ResponseWriter, account Account, sess session, refreshToken string) {
+	tokenString, expirationTime, err := issueToken(account, sess)
 	if err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
+	setTokenCookies(w, tokenString, expirationTime, refreshToken, sess.ExpiresAt)
+
+	// Also return the tokens and user information as JSON
+	response := map[string]interface{}{
+		"token":         tokenString,
+		"expires_at":    expirationTime,
+		"refresh_token": refreshToken,
+		"user": map[string]interface{}{
+			"id":    account.Username,
+			"email": account.Email,
+			"name":  account.Name,
+			"role":  account.Role,
+			// operators with write permissions may be asked to enroll a second factor
+			"two_factor": account.TwoFactor,
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
 
+// setTokenCookies sends an access token and a refresh token as cookies
+func setTokenCookies(w http.ResponseWriter, tokenString string, expirationTime time.Time, refreshToken string, refreshExpiration time.Time) {
 	// Send back the token in a cookie (optional)
 	http.SetCookie(w, &http.Cookie{
 		Name:     "token",
@@ -127,19 +367,16 @@ func login(w http.ResponseWriter, r *http.Request) {
 		Secure:   false, // false for local development
 		SameSite: http.SameSiteStrictMode,
 	})
-
-	// Also return the token and user information as JSON
-	response := map[string]interface{}{
-		"token": tokenString,
-		"user": map[string]interface{}{
-			"id":    "1",
-			"email": creds.Username + "@example.com",
-			"name":  creds.Username,
-		},
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	// The refresh token is only sent to the session endpoints
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshCookie,
+		Value:    refreshToken,
+		Path:     "/dashdata",
+		Expires:  refreshExpiration,
+		HttpOnly: true,
+		Secure:   false, // false for local development
+		SameSite: http.SameSiteStrictMode,
+	})
 }
 
 // authMiddleware validates JWT tokens for protected routes
@@ -151,6 +388,11 @@ func authMiddleware(next http.Handler) http.Handler {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader != "" && len(authHeader) > 7 && authHeader[:7] == "Bearer " {
 			tokenStr = authHeader[7:]
+			// Machine clients authenticate with an API key instead of an access token
+			if strings.HasPrefix(tokenStr, apiKeyPrefix) {
+				apiKeyAuth(w, r, tokenStr, next)
+				return
+			}
 		} else {
 			// Fallback to cookie if no Authorization header
 			c, err := r.Cookie("token")
@@ -170,9 +412,7 @@ func authMiddleware(next http.Handler) http.Handler {
 		}
 
 		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtKey, nil
-		})
+		token, err := parseToken(tokenStr, claims)
 
 		if err != nil {
 			w.Header().Set("Content-Type", "application/json")
@@ -214,12 +454,51 @@ func authMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		// Add username to request context for use in handlers
+		// Tokens revoked by a logout or of a closed session are refused
+		if sessions.isDenied(claims.ID) || (claims.SessionID != "" && !sessions.active(claims.SessionID)) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Token has been revoked", "code": "TOKEN_REVOKED"})
+			return
+		}
+
+		// Tokens of deleted or disabled accounts are refused,
+		// and changes of role or providers apply to the tokens already issued
+		account, ok := accounts.get(claims.Username)
+		if !ok || account.Disabled {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Account is disabled"})
+			return
+		}
+		claims.Role = account.Role
+		claims.Providers = account.Providers
+
+		// Add username and claims to request context for use in handlers
 		r.Header.Set("X-Username", claims.Username)
-		next.ServeHTTP(w, r)
+		ctx := context.WithValue(r.Context(), ClaimsKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// AuthKey is used to store the authenticated user claims in the context.
+type AuthKey string
+
+const ClaimsKey AuthKey = "claims"
+
+// claimsFromContext returns the claims of the authenticated user, or empty claims
+func claimsFromContext(ctx context.Context) *Claims {
+	if claims, ok := ctx.Value(ClaimsKey).(*Claims); ok {
+		return claims
+	}
+	return &Claims{}
+}
+
+// isAdmin tells if the authenticated user is an administrator
+func isAdmin(ctx context.Context) bool {
+	return claimsFromContext(ctx).Role == RoleAdmin
+}
+
 // PaginationKey is used to store pagination parameters in the context.
 type PaginationKey string
 
@@ -254,4 +533,3 @@ func paginate(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
-
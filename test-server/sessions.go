@@ -0,0 +1,319 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// refreshCookie is the cookie holding the refresh token of a browser session
+const refreshCookie = "refresh_token"
+
+var (
+	errInvalidRefreshToken = errors.New("invalid refresh token")
+	errRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+// session is a login session. Its refresh token rotates on every use:
+// only its hash is kept, with the hash of the previous one to detect a stolen token being replayed.
+type session struct {
+	ID         string
+	Username   string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	ExpiresAt  time.Time
+	// MFA tells if the login was confirmed by a second factor
+	MFA bool
+
+	refreshHash  [32]byte
+	previousHash [32]byte
+	revoked      bool
+}
+
+// sessionStore holds the login sessions and the denylist of logged out access tokens
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+	// denied maps the jti of revoked access tokens to their expiration
+	denied map[string]time.Time
+}
+
+var sessions = &sessionStore{
+	sessions: make(map[string]*session),
+	denied:   make(map[string]time.Time),
+}
+
+// newRefreshToken returns a refresh token of a session, formatted as "{session ID}.{random}"
+func newRefreshToken(sessionID string) (string, [32]byte, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", [32]byte{}, err
+	}
+	token := sessionID + "." + base64.RawURLEncoding.EncodeToString(random)
+	return token, sha256.Sum256([]byte(token)), nil
+}
+
+// create opens a session and returns its refresh token
+func (s *sessionStore) create(username string, mfa bool) (session, string, error) {
+	sessionID := newID("sess")
+	refreshToken, hash, err := newRefreshToken(sessionID)
+	if err != nil {
+		return session{}, "", err
+	}
+
+	now := time.Now()
+	sess := &session{
+		ID:          sessionID,
+		Username:    username,
+		CreatedAt:   now,
+		LastUsedAt:  now,
+		ExpiresAt:   now.Add(conf.Tokens.RefreshTTL),
+		MFA:         mfa,
+		refreshHash: hash,
+	}
+	s.mu.Lock()
+	s.sessions[sessionID] = sess
+	s.mu.Unlock()
+	return *sess, refreshToken, nil
+}
+
+// rotate exchanges a refresh token for a new one.
+// Presenting a refresh token already exchanged revokes the session, as it was probably stolen.
+func (s *sessionStore) rotate(refreshToken string) (sess session, newToken string, err error) {
+	sessionID, _, _ := strings.Cut(refreshToken, ".")
+	hash := sha256.Sum256([]byte(refreshToken))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cur, ok := s.sessions[sessionID]
+	if !ok || cur.revoked || time.Now().After(cur.ExpiresAt) {
+		return session{}, "", errInvalidRefreshToken
+	}
+	if subtle.ConstantTimeCompare(hash[:], cur.previousHash[:]) == 1 {
+		cur.revoked = true
+		return session{}, "", errRefreshTokenReused
+	}
+	if subtle.ConstantTimeCompare(hash[:], cur.refreshHash[:]) != 1 {
+		return session{}, "", errInvalidRefreshToken
+	}
+
+	newToken, newHash, err := newRefreshToken(sessionID)
+	if err != nil {
+		return session{}, "", err
+	}
+	cur.previousHash = cur.refreshHash
+	cur.refreshHash = newHash
+	cur.LastUsedAt = time.Now()
+	return *cur, newToken, nil
+}
+
+// active tells if a session is open
+func (s *sessionStore) active(sessionID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[sessionID]
+	return ok && !sess.revoked && time.Now().Before(sess.ExpiresAt)
+}
+
+// revoke closes a session
+func (s *sessionStore) revoke(sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sess, ok := s.sessions[sessionID]; ok {
+		sess.revoked = true
+	}
+}
+
+// revokeRefreshToken closes the session of a refresh token, if the token is the current or the previous one
+// of its session, and tells if it did. The session ID alone is not enough, as it appears in the access tokens.
+func (s *sessionStore) revokeRefreshToken(refreshToken string) bool {
+	sessionID, _, _ := strings.Cut(refreshToken, ".")
+	hash := sha256.Sum256([]byte(refreshToken))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[sessionID]
+	if !ok || (subtle.ConstantTimeCompare(hash[:], sess.refreshHash[:]) != 1 &&
+		subtle.ConstantTimeCompare(hash[:], sess.previousHash[:]) != 1) {
+		return false
+	}
+	sess.revoked = true
+	return true
+}
+
+// revokeUser closes every session of a user, and returns the number of closed sessions
+func (s *sessionStore) revokeUser(username string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, sess := range s.sessions {
+		if sess.Username == username && !sess.revoked {
+			sess.revoked = true
+			count++
+		}
+	}
+	return count
+}
+
+// deny revokes an access token until its expiration
+func (s *sessionStore) deny(jti string, expiresAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	// forget the tokens which expired anyway, and the closed sessions
+	for id, until := range s.denied {
+		if now.After(until) {
+			delete(s.denied, id)
+		}
+	}
+	for id, sess := range s.sessions {
+		if now.After(sess.ExpiresAt) {
+			delete(s.sessions, id)
+		}
+	}
+	s.denied[jti] = expiresAt
+}
+
+// isDenied tells if an access token was revoked
+func (s *sessionStore) isDenied(jti string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.denied[jti]
+	return ok
+}
+
+// refreshTokenFrom reads the refresh token of a request, from its cookie or its JSON body
+func refreshTokenFrom(r *http.Request) string {
+	if c, err := r.Cookie(refreshCookie); err == nil && c.Value != "" {
+		return c.Value
+	}
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	return body.RefreshToken
+}
+
+// clearCookie removes a cookie from the browser
+func clearCookie(w http.ResponseWriter, name, path string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    "",
+		Path:     path,
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh token
+func Refresh(w http.ResponseWriter, r *http.Request) {
+	refreshToken := refreshTokenFrom(r)
+	if refreshToken == "" {
+		writeProblem(w, http.StatusUnauthorized, "REFRESH_TOKEN_MISSING", "no refresh token provided")
+		return
+	}
+
+	sess, newToken, err := sessions.rotate(refreshToken)
+	if errors.Is(err, errRefreshTokenReused) {
+		sessionID, _, _ := strings.Cut(refreshToken, ".")
+		log.Printf("🔐 Refresh token reused, session %s revoked", sessionID)
+	}
+	if err != nil {
+		clearCookie(w, refreshCookie, "/dashdata")
+		writeProblem(w, http.StatusUnauthorized, "REFRESH_TOKEN_INVALID", "the refresh token is invalid, expired or revoked")
+		return
+	}
+
+	account, ok := accounts.get(sess.Username)
+	if !ok || account.Disabled {
+		sessions.revoke(sess.ID)
+		clearCookie(w, refreshCookie, "/dashdata")
+		writeProblem(w, http.StatusUnauthorized, "ACCOUNT_DISABLED", "the account is disabled")
+		return
+	}
+
+	writeTokens(w, account, sess, newToken)
+}
+
+// Logout closes the session of the caller: its access token is revoked, its refresh token
+// can no longer be used, and the authentication cookies are cleared.
+// It succeeds even if the access token already expired.
+func Logout(w http.ResponseWriter, r *http.Request) {
+	var tokenStr string
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		tokenStr = strings.TrimPrefix(auth, "Bearer ")
+	} else if c, err := r.Cookie("token"); err == nil {
+		tokenStr = c.Value
+	}
+
+	username := ""
+	if tokenStr != "" {
+		// the signature is verified, but not the expiration, so that an expired token closes its session
+		claims := &Claims{}
+		if _, err := parseToken(tokenStr, claims, jwt.WithoutClaimsValidation()); err == nil {
+			username = claims.Username
+			if claims.ID != "" && claims.ExpiresAt != nil {
+				sessions.deny(claims.ID, claims.ExpiresAt.Time)
+			}
+			if claims.SessionID != "" {
+				sessions.revoke(claims.SessionID)
+			}
+		}
+	}
+	if refreshToken := refreshTokenFrom(r); refreshToken != "" {
+		sessions.revokeRefreshToken(refreshToken)
+	}
+
+	clearCookie(w, "token", "")
+	clearCookie(w, refreshCookie, "/dashdata")
+	if username != "" {
+		log.Println("🔐 Logout:", username)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Logged out",
+	})
+}
+
+// RevokeAccountSessions closes every session of an operator, forcing a new login
+func RevokeAccountSessions(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+	if _, ok := accounts.get(username); !ok {
+		writeAccountError(w, username, errAccountNotFound)
+		return
+	}
+	count := sessions.revokeUser(username)
+	log.Printf("🔐 %d sessions of %s revoked by %s", count, username, claimsFromContext(r.Context()).Username)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Sessions revoked successfully",
+		"count":   count,
+	})
+}
@@ -0,0 +1,94 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// newSessionStore returns an empty session store, whose sessions last for ttl
+func newSessionStore(t *testing.T, ttl time.Duration) *sessionStore {
+	saved := conf.Tokens.RefreshTTL
+	t.Cleanup(func() { conf.Tokens.RefreshTTL = saved })
+	conf.Tokens.RefreshTTL = ttl
+	return &sessionStore{sessions: make(map[string]*session), denied: make(map[string]time.Time)}
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	s := newSessionStore(t, time.Hour)
+	sess, first, err := s.create("operator", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, second, err := s.rotate(first)
+	if err != nil {
+		t.Fatalf("rotation: %v", err)
+	}
+	if _, _, err := s.rotate(sess.ID + ".forged"); err != errInvalidRefreshToken {
+		t.Errorf("rotation of a forged token = %v, want %v", err, errInvalidRefreshToken)
+	}
+
+	// presenting the exchanged token again closes the session, the current token included
+	if _, _, err := s.rotate(first); err != errRefreshTokenReused {
+		t.Errorf("reuse of an exchanged token = %v, want %v", err, errRefreshTokenReused)
+	}
+	if _, _, err := s.rotate(second); err != errInvalidRefreshToken {
+		t.Errorf("rotation after a reuse = %v, want %v", err, errInvalidRefreshToken)
+	}
+	if s.active(sess.ID) {
+		t.Error("the session is still active after a reuse")
+	}
+}
+
+func TestRefreshTokenExpiration(t *testing.T) {
+	s := newSessionStore(t, -time.Second)
+	_, token, err := s.create("operator", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.rotate(token); err != errInvalidRefreshToken {
+		t.Errorf("rotation of an expired token = %v, want %v", err, errInvalidRefreshToken)
+	}
+}
+
+func TestRevokeRefreshToken(t *testing.T) {
+	s := newSessionStore(t, time.Hour)
+	sess, token, err := s.create("operator", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the session ID is known from the access tokens, and must not be enough to log out
+	if s.revokeRefreshToken(sess.ID + ".forged") {
+		t.Error("a forged refresh token closed the session")
+	}
+	if !s.active(sess.ID) {
+		t.Fatal("the session was closed by a forged refresh token")
+	}
+	if !s.revokeRefreshToken(token) || s.active(sess.ID) {
+		t.Error("the refresh token did not close its session")
+	}
+}
+
+func TestRevokeUserSessions(t *testing.T) {
+	s := newSessionStore(t, time.Hour)
+	_, aliceToken, _ := s.create("alice", false)
+	s.create("alice", true)
+	_, bobToken, _ := s.create("bob", false)
+
+	if count := s.revokeUser("alice"); count != 2 {
+		t.Errorf("revokeUser = %d, want 2", count)
+	}
+	if _, _, err := s.rotate(aliceToken); err != errInvalidRefreshToken {
+		t.Errorf("rotation of a revoked session = %v, want %v", err, errInvalidRefreshToken)
+	}
+	if _, _, err := s.rotate(bobToken); err != nil {
+		t.Errorf("rotation of another user: %v", err)
+	}
+}
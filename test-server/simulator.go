@@ -0,0 +1,80 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// simulateActivity periodically creates licenses and registers devices,
+// so that the live activity of the dashboard can be demonstrated with the test server.
+func simulateActivity(interval time.Duration) {
+	log.Println("Simulating license activity every", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	n := 0
+	for range ticker.C {
+		n++
+		if n%2 == 1 {
+			simulateLicense(n)
+			continue
+		}
+
+		// register a new device on a random live license
+		live := store.list(context.Background(), func(rec *licenseRecord) bool {
+			return rec.Status == StatusReady || rec.Status == StatusActive
+		})
+		if len(live) == 0 {
+			continue
+		}
+		rec := live[rand.Intn(len(live))]
+		ingest(context.Background(), IngestedEvent{
+			LicenseID:  rec.UUID,
+			Type:       "register",
+			Timestamp:  time.Now(),
+			DeviceID:   fmt.Sprintf("sim-device-%d", n),
+			DeviceName: fmt.Sprintf("Simulated device %d", n),
+		})
+	}
+}
+
+// simulateLicense adds a new license on a random publication
+func simulateLicense(n int) {
+	publications := []struct{ id, title, provider string }{
+		{"pub-123", "The Complete Guide to Modern Web Development with React and TypeScript", "Provider A"},
+		{"pub-456", "Advanced JavaScript Patterns", "Provider B"},
+		{"pub-789", "Mastering Node.js: Build Scalable Applications", "Provider C"},
+		{"pub-212", "Python for Data Science and Machine Learning", "Provider D"},
+	}
+	pub := publications[rand.Intn(len(publications))]
+	now := time.Now()
+
+	store.add(&licenseRecord{
+		LicenseInfo: LicenseInfo{
+			CreatedAt:        now,
+			UpdatedAt:        now,
+			UUID:             fmt.Sprintf("sim-%d-%d", now.Unix(), n),
+			Provider:         stringPtr(pub.provider),
+			UserID:           fmt.Sprintf("sim-user-%03d", rand.Intn(100)),
+			Start:            now.Format(time.RFC3339),
+			End:              now.AddDate(0, 0, 21).Format(time.RFC3339),
+			MaxEnd:           stringPtr(now.AddDate(0, 0, 42).Format(time.RFC3339)),
+			Copy:             10,
+			Print:            10,
+			Status:           StatusReady,
+			PublicationID:    pub.id,
+			PublicationTitle: pub.title,
+		},
+		Type: "loan",
+	})
+}
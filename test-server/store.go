@@ -0,0 +1,414 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// License statuses, as defined by the LCP License Status Document specification
+const (
+	StatusReady     = "ready"
+	StatusActive    = "active"
+	StatusRevoked   = "revoked"
+	StatusReturned  = "returned"
+	StatusCancelled = "cancelled"
+	StatusExpired   = "expired"
+)
+
+// oversharedDeviceThreshold is the number of devices from which a license is considered overshared
+const oversharedDeviceThreshold = 3
+
+var errLicenseNotFound = errors.New("license not found")
+
+// licenseRecord holds a license and the data the LCP server keeps alongside it.
+type licenseRecord struct {
+	LicenseInfo
+	AltID     string
+	UserEmail string
+	Type      string
+	// ContentType is the media type of the publication, EPUB if empty
+	ContentType string
+	Events      []Event
+	Devices     []Device
+}
+
+// licenseStore is an in-memory license repository, safe for concurrent use.
+type licenseStore struct {
+	mu       sync.RWMutex
+	licenses map[string]*licenseRecord
+}
+
+var store = newLicenseStore()
+
+func newLicenseStore() *licenseStore {
+	s := &licenseStore{licenses: make(map[string]*licenseRecord)}
+	for _, rec := range seedLicenses() {
+		rec.seedDevices()
+		s.licenses[rec.UUID] = rec
+	}
+	return s
+}
+
+// The read and update methods of the store are scoped to the tenant of the context:
+// the licenses of other providers are handled as if they did not exist.
+
+// get returns a copy of a license record
+func (s *licenseStore) get(ctx context.Context, licenseID string) (licenseRecord, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rec, ok := s.licenses[licenseID]
+	if !ok || !tenantFor(ctx).allows(rec.provider()) {
+		return licenseRecord{}, false
+	}
+	return rec.copy(), true
+}
+
+// add inserts a new license record
+func (s *licenseStore) add(rec *licenseRecord) {
+	s.mu.Lock()
+	s.licenses[rec.UUID] = rec
+	s.mu.Unlock()
+
+	a := Activity{
+		Type:          ActivityLicenseCreated,
+		LicenseID:     rec.UUID,
+		PublicationID: rec.PublicationID,
+		Status:        rec.Status,
+	}
+	if rec.Provider != nil {
+		a.Provider = *rec.Provider
+	}
+	activities.publish(a)
+}
+
+// update applies fn to a license record while holding the store lock.
+// The UpdatedAt field of the license is refreshed if fn succeeds,
+// and the resulting changes are published to the activity stream.
+func (s *licenseStore) update(ctx context.Context, licenseID string, fn func(rec *licenseRecord) error) error {
+	s.mu.Lock()
+	rec, ok := s.licenses[licenseID]
+	if !ok || !tenantFor(ctx).allows(rec.provider()) {
+		s.mu.Unlock()
+		return errLicenseNotFound
+	}
+	before := rec.copy()
+	if err := fn(rec); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	rec.UpdatedAt = time.Now()
+	changes := licenseActivities(before, rec)
+	s.mu.Unlock()
+
+	for _, a := range changes {
+		activities.publish(a)
+	}
+	return nil
+}
+
+// updateWhere applies fn to every license record accepted by the filter, while holding the store lock.
+// It returns the number of updated records.
+func (s *licenseStore) updateWhere(ctx context.Context, filter func(rec *licenseRecord) bool, fn func(rec *licenseRecord)) int {
+	t := tenantFor(ctx)
+	s.mu.Lock()
+	count := 0
+	changes := []Activity{}
+	for _, rec := range s.licenses {
+		if t.allows(rec.provider()) && filter(rec) {
+			before := rec.copy()
+			fn(rec)
+			rec.UpdatedAt = time.Now()
+			changes = append(changes, licenseActivities(before, rec)...)
+			count++
+		}
+	}
+	s.mu.Unlock()
+
+	for _, a := range changes {
+		activities.publish(a)
+	}
+	return count
+}
+
+// list returns a copy of the license records accepted by the filter, oldest first.
+// A nil filter accepts every record.
+func (s *licenseStore) list(ctx context.Context, filter func(rec *licenseRecord) bool) []licenseRecord {
+	t := tenantFor(ctx)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	records := []licenseRecord{}
+	for _, rec := range s.licenses {
+		if t.allows(rec.provider()) && (filter == nil || filter(rec)) {
+			records = append(records, rec.copy())
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].CreatedAt.Equal(records[j].CreatedAt) {
+			return records[i].UUID < records[j].UUID
+		}
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+	return records
+}
+
+// copy returns a deep copy of the record, so that it can be used outside of the store lock
+func (rec *licenseRecord) copy() licenseRecord {
+	c := *rec
+	c.Events = append([]Event(nil), rec.Events...)
+	c.Devices = append([]Device(nil), rec.Devices...)
+	if rec.Revocation != nil {
+		revocation := *rec.Revocation
+		c.Revocation = &revocation
+	}
+	return c
+}
+
+// provider returns the provider of the license, or an empty string
+func (rec *licenseRecord) provider() string {
+	if rec.Provider == nil {
+		return ""
+	}
+	return *rec.Provider
+}
+
+// addEvent appends an event to the license history
+func (rec *licenseRecord) addEvent(eventType, operator, reason, note string) {
+	rec.Events = append(rec.Events, Event{
+		Timestamp: time.Now().Format(time.RFC3339),
+		Type:      eventType,
+		Operator:  operator,
+		Reason:    reason,
+		Note:      note,
+	})
+}
+
+// seedLicenses returns the mock licenses served by the test server
+func seedLicenses() []*licenseRecord {
+	now := time.Now()
+	return []*licenseRecord{
+		{
+			LicenseInfo: LicenseInfo{
+				CreatedAt:        now.AddDate(0, -2, 0),
+				UpdatedAt:        now.AddDate(0, -1, 0),
+				UUID:             "license-001-user123",
+				Provider:         stringPtr("EDRLab"),
+				UserID:           "user123",
+				Start:            "2024-01-15T00:00:00Z",
+				End:              "2024-12-31T23:59:59Z",
+				MaxEnd:           stringPtr("2025-01-31T23:59:59Z"),
+				Copy:             5,
+				Print:            10,
+				Status:           StatusActive,
+				DeviceCount:      3,
+				PublicationID:    "pub-001",
+				PublicationTitle: "Introduction to Digital Publishing",
+			},
+			UserEmail: "user123@example.com",
+			Type:      "loan",
+			Events: []Event{
+				{
+					Timestamp:  now.AddDate(0, 0, -7).Format(time.RFC3339),
+					Type:       "register",
+					DeviceName: "John's iPad",
+					DeviceID:   "device-001",
+				},
+				{
+					Timestamp:  now.AddDate(0, 0, -5).Format(time.RFC3339),
+					Type:       "return",
+					DeviceName: "John's iPad",
+					DeviceID:   "device-001",
+				},
+				{
+					Timestamp:  now.AddDate(0, 0, -3).Format(time.RFC3339),
+					Type:       "register",
+					DeviceName: "John's iPhone",
+					DeviceID:   "device-002",
+				},
+			},
+		},
+		{
+			LicenseInfo: LicenseInfo{
+				CreatedAt:        now.AddDate(0, -1, 0),
+				UpdatedAt:        now.AddDate(0, 0, -5),
+				UUID:             "license-002-user123",
+				UserID:           "user123",
+				Start:            "2024-06-01T00:00:00Z",
+				End:              "2024-11-30T23:59:59Z",
+				Copy:             3,
+				Print:            5,
+				Status:           StatusExpired,
+				DeviceCount:      2,
+				PublicationID:    "pub-002",
+				PublicationTitle: "Advanced eBook Technologies",
+			},
+			UserEmail: "user123@example.com",
+			Type:      "loan",
+			Events: []Event{
+				{
+					Timestamp:  now.AddDate(0, 0, -10).Format(time.RFC3339),
+					Type:       "register",
+					DeviceName: "MacBook Pro",
+					DeviceID:   "device-003",
+				},
+				{
+					Timestamp:  now.AddDate(0, 0, -1).Format(time.RFC3339),
+					Type:       "renew",
+					DeviceName: "MacBook Pro",
+					DeviceID:   "device-003",
+				},
+			},
+		},
+		{
+			LicenseInfo: LicenseInfo{
+				CreatedAt:        now.AddDate(0, -3, 0),
+				UpdatedAt:        now.AddDate(0, 0, -10),
+				UUID:             "license-003-johndoe",
+				Provider:         stringPtr("LibrarySystem"),
+				UserID:           "john.doe",
+				Start:            "2024-03-01T00:00:00Z",
+				End:              "2025-03-01T00:00:00Z",
+				Copy:             10,
+				Print:            20,
+				Status:           StatusActive,
+				DeviceCount:      5,
+				PublicationID:    "pub-003",
+				PublicationTitle: "Modern Library Management",
+			},
+			UserEmail: "john.doe@example.com",
+			Type:      "buy",
+			Events: []Event{
+				{
+					Timestamp:  now.AddDate(0, 0, -20).Format(time.RFC3339),
+					Type:       "register",
+					DeviceName: "Library Tablet 1",
+					DeviceID:   "lib-tablet-001",
+				},
+				{
+					Timestamp:  now.AddDate(0, 0, -15).Format(time.RFC3339),
+					Type:       "register",
+					DeviceName: "Library Tablet 2",
+					DeviceID:   "lib-tablet-002",
+				},
+				{
+					Timestamp:  now.AddDate(0, 0, -10).Format(time.RFC3339),
+					Type:       "return",
+					DeviceName: "Library Tablet 1",
+					DeviceID:   "lib-tablet-001",
+				},
+			},
+		},
+		{
+			LicenseInfo: LicenseInfo{
+				CreatedAt:        now.AddDate(0, -4, 0),
+				UpdatedAt:        now.AddDate(0, 0, -2),
+				UUID:             "lic-001",
+				Provider:         stringPtr("Provider A"),
+				UserID:           "user-001",
+				Start:            now.AddDate(0, -4, 0).Format(time.RFC3339),
+				End:              now.AddDate(0, 1, 0).Format(time.RFC3339),
+				MaxEnd:           stringPtr(now.AddDate(0, 2, 0).Format(time.RFC3339)),
+				Copy:             10,
+				Print:            10,
+				Status:           StatusActive,
+				DeviceCount:      5,
+				PublicationID:    "pub-123",
+				PublicationTitle: "The Complete Guide to Modern Web Development with React and TypeScript",
+			},
+			AltID:     "alt-123",
+			UserEmail: "john.doe@example.com",
+			Type:      "loan",
+		},
+		{
+			LicenseInfo: LicenseInfo{
+				CreatedAt:        now.AddDate(0, -3, -12),
+				UpdatedAt:        now.AddDate(0, 0, -6),
+				UUID:             "lic-002",
+				Provider:         stringPtr("Provider B"),
+				UserID:           "user-002",
+				Start:            now.AddDate(0, -3, -12).Format(time.RFC3339),
+				Copy:             10,
+				Print:            10,
+				Status:           StatusReady,
+				DeviceCount:      4,
+				PublicationID:    "pub-456",
+				PublicationTitle: "Advanced JavaScript Patterns",
+			},
+			AltID:       "alt-456",
+			Type:        "buy",
+			ContentType: contentTypePDF,
+		},
+		{
+			LicenseInfo: LicenseInfo{
+				CreatedAt:        now.AddDate(0, -2, -20),
+				UpdatedAt:        now.AddDate(0, 0, -1),
+				UUID:             "lic-003",
+				Provider:         stringPtr("Provider C"),
+				UserID:           "user-003",
+				Start:            now.AddDate(0, -2, -20).Format(time.RFC3339),
+				End:              now.AddDate(0, 0, 20).Format(time.RFC3339),
+				MaxEnd:           stringPtr(now.AddDate(0, 1, 20).Format(time.RFC3339)),
+				Copy:             10,
+				Print:            10,
+				Status:           StatusActive,
+				DeviceCount:      6,
+				PublicationID:    "pub-789",
+				PublicationTitle: "Mastering Node.js: Build Scalable Applications",
+			},
+			AltID:     "alt-789",
+			UserEmail: "bob.wilson@example.com",
+			Type:      "loan",
+		},
+		{
+			LicenseInfo: LicenseInfo{
+				CreatedAt:        now.AddDate(0, -6, 0),
+				UpdatedAt:        now.AddDate(0, -1, 0),
+				UUID:             "lic-004",
+				Provider:         stringPtr("Provider A"),
+				UserID:           "user-004",
+				Start:            now.AddDate(0, -6, 0).Format(time.RFC3339),
+				End:              now.AddDate(0, -1, 0).Format(time.RFC3339),
+				Copy:             10,
+				Print:            10,
+				Status:           StatusExpired,
+				DeviceCount:      3,
+				PublicationID:    "pub-101",
+				PublicationTitle: "CSS Grid and Flexbox: A Complete Guide",
+			},
+			AltID:     "alt-101",
+			UserEmail: "alice.brown@example.com",
+			Type:      "buy",
+		},
+		{
+			LicenseInfo: LicenseInfo{
+				CreatedAt:        now.AddDate(0, -1, -10),
+				UpdatedAt:        now.AddDate(0, 0, -3),
+				UUID:             "lic-005",
+				Provider:         stringPtr("Provider D"),
+				UserID:           "user-005",
+				Start:            now.AddDate(0, -1, -10).Format(time.RFC3339),
+				End:              now.AddDate(0, 0, 10).Format(time.RFC3339),
+				MaxEnd:           stringPtr(now.AddDate(0, 1, 10).Format(time.RFC3339)),
+				Copy:             10,
+				Print:            10,
+				Status:           StatusActive,
+				DeviceCount:      7,
+				PublicationID:    "pub-212",
+				PublicationTitle: "Python for Data Science and Machine Learning",
+			},
+			AltID:     "alt-212",
+			UserEmail: "charlie.davis@example.com",
+			Type:      "loan",
+		},
+	}
+}
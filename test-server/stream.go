@@ -0,0 +1,230 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Activity types pushed to the live activity stream
+const (
+	ActivityLicenseCreated   = "license.created"
+	ActivityStatusChanged    = "license.status_changed"
+	ActivityDeviceRegistered = "device.registered"
+	ActivityOvershare        = "license.overshared"
+)
+
+// activityHistorySize is the number of activities kept to resume a stream
+const activityHistorySize = 1000
+
+// streamHeartbeat is the delay between two keep-alive comments on an idle stream
+const streamHeartbeat = 30 * time.Second
+
+// Activity is an event of the live activity stream.
+// It carries no personal data, as it is broadcast to every operator.
+type Activity struct {
+	ID             int64     `json:"id"`
+	Type           string    `json:"type"`
+	Timestamp      time.Time `json:"timestamp"`
+	LicenseID      string    `json:"license_id"`
+	PublicationID  string    `json:"publication_id,omitempty"`
+	Provider       string    `json:"provider,omitempty"`
+	Status         string    `json:"status,omitempty"`
+	PreviousStatus string    `json:"previous_status,omitempty"`
+	DeviceID       string    `json:"device_id,omitempty"`
+	DeviceCount    int       `json:"device_count,omitempty"`
+	Operator       string    `json:"operator,omitempty"`
+}
+
+// activityBroker dispatches activities to the stream subscribers,
+// and keeps a short history for the clients resuming a stream.
+type activityBroker struct {
+	mu          sync.Mutex
+	lastID      int64
+	history     []Activity
+	subscribers map[chan Activity]struct{}
+	// listeners are called synchronously on each activity, and must not block
+	listeners []func(Activity)
+}
+
+var activities = &activityBroker{subscribers: make(map[chan Activity]struct{})}
+
+// listen registers a function called on each published activity
+func (b *activityBroker) listen(fn func(Activity)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listeners = append(b.listeners, fn)
+}
+
+// publish numbers an activity and sends it to the subscribers.
+// A subscriber too slow to keep up is disconnected.
+func (b *activityBroker) publish(a Activity) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	a.ID = b.lastID
+	if a.Timestamp.IsZero() {
+		a.Timestamp = time.Now()
+	}
+	b.history = append(b.history, a)
+	if len(b.history) > activityHistorySize {
+		b.history = b.history[len(b.history)-activityHistorySize:]
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- a:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	for _, fn := range b.listeners {
+		fn(a)
+	}
+}
+
+// subscribe registers a new subscriber, and returns the activities published after lastID
+func (b *activityBroker) subscribe(lastID int64) (chan Activity, []Activity) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan Activity, 64)
+	b.subscribers[ch] = struct{}{}
+
+	backlog := []Activity{}
+	if lastID > 0 {
+		for _, a := range b.history {
+			if a.ID > lastID {
+				backlog = append(backlog, a)
+			}
+		}
+	}
+	return ch, backlog
+}
+
+func (b *activityBroker) unsubscribe(ch chan Activity) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// licenseActivities compares a license record before and after an update,
+// and returns the corresponding activities
+func licenseActivities(before licenseRecord, after *licenseRecord) []Activity {
+	base := Activity{
+		LicenseID:     after.UUID,
+		PublicationID: after.PublicationID,
+		Status:        after.Status,
+		DeviceCount:   after.DeviceCount,
+	}
+	if after.Provider != nil {
+		base.Provider = *after.Provider
+	}
+	if len(after.Events) > len(before.Events) {
+		base.Operator = after.Events[len(after.Events)-1].Operator
+	}
+
+	list := []Activity{}
+	if after.Status != before.Status {
+		a := base
+		a.Type = ActivityStatusChanged
+		a.PreviousStatus = before.Status
+		list = append(list, a)
+	}
+	for _, device := range after.Devices {
+		if before.device(device.ID) == nil {
+			a := base
+			a.Type = ActivityDeviceRegistered
+			a.DeviceID = device.ID
+			list = append(list, a)
+		}
+	}
+	if before.DeviceCount < oversharedDeviceThreshold && after.DeviceCount >= oversharedDeviceThreshold {
+		a := base
+		a.Type = ActivityOvershare
+		list = append(list, a)
+	}
+	return list
+}
+
+// Stream sends the live license activity as Server-Sent Events.
+// A client can resume a stream using the Last-Event-ID header or the last_event_id query parameter.
+func Stream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeProblem(w, http.StatusInternalServerError, "", "streaming is not supported")
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	lastID, _ := strconv.ParseInt(lastEventID, 10, 64)
+
+	ch, backlog := activities.subscribe(lastID)
+	defer activities.unsubscribe(ch)
+	// operators bound to providers only receive the activities of their providers
+	t := tenantFor(r.Context())
+
+	log.Printf("📡 Activity stream opened by %s", claimsFromContext(r.Context()).Username)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 5000\n\n")
+	for _, a := range backlog {
+		if t.allows(a.Provider) {
+			writeActivity(w, a)
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case a, ok := <-ch:
+			if !ok {
+				// the subscriber was dropped, the client will reconnect and resume
+				return
+			}
+			if !t.allows(a.Provider) {
+				continue
+			}
+			writeActivity(w, a)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+func writeActivity(w http.ResponseWriter, a Activity) {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", a.ID, a.Type, data)
+}
@@ -0,0 +1,78 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+)
+
+// tenant is the set of providers whose data an operator can see and change.
+// An operator bound to no provider has access to every provider.
+//
+// The tenant is read from the claims held by the request context, by the store and the other
+// data sources, so that handlers cannot forget it. Internal callers (ingestion, simulation,
+// alert evaluation) use a context without claims, which is not restricted.
+type tenant struct {
+	providers []string
+}
+
+func tenantFor(ctx context.Context) tenant {
+	return tenant{providers: claimsFromContext(ctx).Providers}
+}
+
+// restricted tells if the operator is bound to some providers
+func (t tenant) restricted() bool {
+	return len(t.providers) > 0
+}
+
+// allows tells if the operator has access to the data of a provider
+func (t tenant) allows(provider string) bool {
+	return !t.restricted() || slices.Contains(t.providers, provider)
+}
+
+// covers tells if the operator has access to the data of all the providers of a list.
+// An empty list stands for every provider, only covered by the operators bound to no provider.
+func (t tenant) covers(providers []string) bool {
+	if !t.restricted() {
+		return true
+	}
+	if len(providers) == 0 {
+		return false
+	}
+	for _, provider := range providers {
+		if !t.allows(provider) {
+			return false
+		}
+	}
+	return true
+}
+
+// scoped returns the items of a data source which belong to the providers visible to the operator
+func scoped[T any](ctx context.Context, items []T, provider func(T) string) []T {
+	t := tenantFor(ctx)
+	visible := []T{}
+	for _, item := range items {
+		if t.allows(provider(item)) {
+			visible = append(visible, item)
+		}
+	}
+	return visible
+}
+
+// requireAllProviders restricts a route to the operators bound to no provider,
+// typically for settings which apply to every provider
+func requireAllProviders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tenantFor(r.Context()).restricted() {
+			writeProblem(w, http.StatusForbidden, "TENANT_FORBIDDEN", "this operation is not available to operators bound to providers")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
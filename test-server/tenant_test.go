@@ -0,0 +1,79 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// contextWithClaims returns a context authenticated with the given claims
+func contextWithClaims(claims *Claims) context.Context {
+	return context.WithValue(context.Background(), ClaimsKey, claims)
+}
+
+func TestStoreTenantScoping(t *testing.T) {
+	ctx := contextWithClaims(&Claims{Username: "operator", Providers: []string{"Provider A"}})
+
+	for _, rec := range store.list(ctx, nil) {
+		if rec.provider() != "Provider A" {
+			t.Errorf("list returned %s of provider %q", rec.UUID, rec.provider())
+		}
+	}
+	if _, ok := store.get(ctx, "lic-004"); !ok {
+		t.Error("get did not find a license of the provider")
+	}
+	// licenses of other providers, or without provider, are not found
+	for _, id := range []string{"lic-002", "license-002-user123"} {
+		if _, ok := store.get(ctx, id); ok {
+			t.Errorf("get found %s", id)
+		}
+		if err := store.update(ctx, id, func(rec *licenseRecord) error { return nil }); err != errLicenseNotFound {
+			t.Errorf("update of %s = %v, want %v", id, err, errLicenseNotFound)
+		}
+	}
+
+	if _, ok := store.get(contextWithClaims(&Claims{Username: "admin"}), "license-002-user123"); !ok {
+		t.Error("an unrestricted operator did not find a license without provider")
+	}
+}
+
+func TestScopedSources(t *testing.T) {
+	ctx := contextWithClaims(&Claims{Providers: []string{"Unknown Provider"}})
+
+	if publications := publicationsFor(ctx); len(publications) != 0 {
+		t.Errorf("got %d publications of an unknown provider", len(publications))
+	}
+	if rows := licenseReport(ctx, seedLicenses()[0].CreatedAt); len(rows) != 0 {
+		t.Errorf("got %d report rows of an unknown provider", len(rows))
+	}
+	issuedLicenses(ctx, func(day issuanceDay) {
+		t.Fatalf("issuance of provider %q is visible", day.Provider)
+	})
+}
+
+func TestRequireAllProviders(t *testing.T) {
+	h := requireAllProviders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodPost, "/dashdata/accounts", nil)
+	req = req.WithContext(contextWithClaims(&Claims{Username: "admin", Role: RoleAdmin, Providers: []string{"Provider A"}}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d for an operator bound to a provider, want %d", w.Code, http.StatusForbidden)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/dashdata/accounts", nil)
+	req = req.WithContext(contextWithClaims(&Claims{Username: "admin", Role: RoleAdmin}))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d for an unrestricted operator, want %d", w.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,460 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// TOTP parameters (RFC 6238), the defaults of the authenticator apps
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew is the number of periods accepted before and after the current one, for clock drifts
+	totpSkew = 1
+)
+
+const (
+	recoveryCodeCount = 10
+	// secondFactorTTL is the time given to send a code after the password was accepted
+	secondFactorTTL = 5 * time.Minute
+	// secondFactorAttempts is the number of wrong codes accepted for a password login
+	secondFactorAttempts = 5
+)
+
+var (
+	errInvalidCode          = errors.New("invalid code")
+	errTwoFactorEnabled     = errors.New("two-factor authentication is already enabled")
+	errTwoFactorNotEnabled  = errors.New("two-factor authentication is not enabled")
+	errTwoFactorNotEnrolled = errors.New("no TOTP secret is waiting for confirmation")
+	errTwoFactorSSO         = errors.New("the second factor of a single sign-on account is managed by the identity provider")
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpCode computes the code of a time step (HOTP of RFC 4226, using HMAC-SHA1)
+func totpCode(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// newTOTPSecret returns a random secret, base32 encoded as expected by authenticator apps
+func newTOTPSecret() string {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return base32NoPadding.EncodeToString(secret)
+}
+
+// provisioningURI returns the otpauth URI of a secret, shown as a QR code to authenticator apps
+func provisioningURI(username, secret string) string {
+	label := url.PathEscape(conf.TwoFactor.Issuer + ":" + username)
+	params := url.Values{
+		"secret":    {secret},
+		"issuer":    {conf.TwoFactor.Issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(totpPeriod)},
+	}
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// checkTOTP verifies a TOTP code of the account. A code is only accepted once,
+// and codes older than the last accepted one are refused, so that an observed code cannot be replayed.
+func (a *Account) checkTOTP(code string, now time.Time) bool {
+	secret, err := base32NoPadding.DecodeString(a.TOTPSecret)
+	if err != nil || len(code) != totpDigits {
+		return false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= a.TOTPLastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			a.TOTPLastStep = step
+			return true
+		}
+	}
+	return false
+}
+
+// newRecoveryCodes returns recovery codes, and the hashes kept in the account
+func newRecoveryCodes() (codes, hashes []string) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		code := strings.ToLower(hex.EncodeToString(b))
+		code = code[:5] + "-" + code[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes
+}
+
+// hashRecoveryCode hashes a recovery code, ignoring its case and dashes.
+// The codes are random enough for a fast hash.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(code, "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// useRecoveryCode consumes a recovery code of the account
+func (a *Account) useRecoveryCode(code string) bool {
+	hash := hashRecoveryCode(code)
+	for i, h := range a.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			a.RecoveryCodes = append(a.RecoveryCodes[:i:i], a.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// checkSecondFactor verifies a TOTP code or consumes a recovery code
+func (a *Account) checkSecondFactor(code string) error {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if !a.TwoFactor {
+		return errTwoFactorNotEnabled
+	}
+	if a.checkTOTP(code, time.Now()) || a.useRecoveryCode(code) {
+		return nil
+	}
+	return errInvalidCode
+}
+
+// secondFactorChallenge is a password login waiting for its second factor
+type secondFactorChallenge struct {
+	username  string
+	expiresAt time.Time
+	attempts  int
+}
+
+// secondFactorChallenges are the password logins waiting for their second factor, by mfa_token
+var secondFactorChallenges = struct {
+	sync.Mutex
+	m map[string]*secondFactorChallenge
+}{m: make(map[string]*secondFactorChallenge)}
+
+// startSecondFactor answers a password login of an account with two-factor authentication:
+// no access token is issued, but an mfa_token to send with the code to /dashdata/login/2fa
+func startSecondFactor(w http.ResponseWriter, account Account) {
+	token := randomToken()
+	expiresAt := time.Now().Add(secondFactorTTL)
+
+	secondFactorChallenges.Lock()
+	for t, c := range secondFactorChallenges.m {
+		if time.Now().After(c.expiresAt) {
+			delete(secondFactorChallenges.m, t)
+		}
+	}
+	secondFactorChallenges.m[token] = &secondFactorChallenge{username: account.Username, expiresAt: expiresAt}
+	secondFactorChallenges.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"mfa_required": true,
+		"mfa_token":    token,
+		"expires_at":   expiresAt,
+	})
+}
+
+// twoFactorRequest is the body of the second login step and of the two-factor settings changes
+type twoFactorRequest struct {
+	MFAToken string `json:"mfa_token,omitempty"`
+	Code     string `json:"code"`
+}
+
+// LoginSecondFactor completes a password login with a TOTP code or a recovery code,
+// and issues the access token of a session confirmed by a second factor
+func LoginSecondFactor(w http.ResponseWriter, r *http.Request) {
+	var req twoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, "INVALID_BODY", "invalid body")
+		return
+	}
+
+	secondFactorChallenges.Lock()
+	challenge, ok := secondFactorChallenges.m[req.MFAToken]
+	if ok && time.Now().After(challenge.expiresAt) {
+		delete(secondFactorChallenges.m, req.MFAToken)
+		ok = false
+	}
+	secondFactorChallenges.Unlock()
+	if !ok {
+		writeProblem(w, http.StatusUnauthorized, "MFA_TOKEN_INVALID", "the login expired, please enter your password again")
+		return
+	}
+
+	auditOperator(r, challenge.username)
+	ip := clientIP(r)
+	if wait := loginLimits.retryAfter(challenge.username, ip); wait > 0 {
+		refuseLogin(w, challenge.username, ip, wait)
+		return
+	}
+
+	account, err := accounts.update(challenge.username, func(a *Account) error {
+		if a.Disabled {
+			return errAccountDisabled
+		}
+		return a.checkSecondFactor(req.Code)
+	})
+	if err != nil {
+		secondFactorChallenges.Lock()
+		challenge.attempts++
+		if challenge.attempts >= secondFactorAttempts {
+			delete(secondFactorChallenges.m, req.MFAToken)
+		}
+		secondFactorChallenges.Unlock()
+		log.Printf("🔐 Second factor refused for %q: %v", challenge.username, err)
+		if errors.Is(err, errInvalidCode) {
+			loginLimits.recordFailure(challenge.username, ip, "invalid_code")
+		}
+		writeProblem(w, http.StatusUnauthorized, "INVALID_CODE", "the code is invalid")
+		return
+	}
+	loginLimits.recordSuccess(account.Username)
+
+	secondFactorChallenges.Lock()
+	delete(secondFactorChallenges.m, req.MFAToken)
+	secondFactorChallenges.Unlock()
+
+	log.Println("🔐 User (second factor):", account.Username)
+
+	sess, refreshToken, err := sessions.create(account.Username, true)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeTokens(w, account, sess, refreshToken)
+}
+
+// writeTwoFactorError turns a two-factor error into a problem
+func writeTwoFactorError(w http.ResponseWriter, username string, err error) {
+	switch {
+	case errors.Is(err, errInvalidCode):
+		writeProblem(w, http.StatusBadRequest, "INVALID_CODE", "the code is invalid")
+	case errors.Is(err, errTwoFactorEnabled), errors.Is(err, errTwoFactorNotEnabled),
+		errors.Is(err, errTwoFactorNotEnrolled), errors.Is(err, errTwoFactorSSO):
+		writeProblem(w, http.StatusConflict, "TWO_FACTOR_STATE", err.Error())
+	default:
+		writeAccountError(w, username, err)
+	}
+}
+
+// TwoFactorStatus tells if the caller uses two-factor authentication
+func TwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	username := claimsFromContext(r.Context()).Username
+	a, ok := accounts.get(username)
+	if !ok {
+		writeAccountError(w, username, errAccountNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":                  a.TwoFactor,
+		"recovery_codes_remaining": accounts.recoveryCodesRemaining(username),
+	})
+}
+
+// EnrollTwoFactor generates the TOTP secret of the caller, to be added to an authenticator app
+// and confirmed with a first code. Logins are unchanged until the confirmation.
+func EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	username := claimsFromContext(r.Context()).Username
+	secret := newTOTPSecret()
+
+	_, err := accounts.update(username, func(a *Account) error {
+		if a.SSOSubject != "" {
+			return errTwoFactorSSO
+		}
+		if a.TwoFactor {
+			return errTwoFactorEnabled
+		}
+		a.TOTPSecret = secret
+		a.TOTPLastStep = 0
+		return nil
+	})
+	if err != nil {
+		writeTwoFactorError(w, username, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"secret":           secret,
+		"provisioning_uri": provisioningURI(username, secret),
+	})
+}
+
+// ConfirmTwoFactor enables two-factor authentication once a code of the new secret is sent,
+// and returns the recovery codes, which are shown only once
+func ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	username := claimsFromContext(r.Context()).Username
+	var req twoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, "INVALID_BODY", "invalid body")
+		return
+	}
+
+	codes, hashes := newRecoveryCodes()
+	_, err := accounts.update(username, func(a *Account) error {
+		if a.SSOSubject != "" {
+			return errTwoFactorSSO
+		}
+		if a.TwoFactor {
+			return errTwoFactorEnabled
+		}
+		if a.TOTPSecret == "" {
+			return errTwoFactorNotEnrolled
+		}
+		if !a.checkTOTP(strings.TrimSpace(req.Code), time.Now()) {
+			return errInvalidCode
+		}
+		a.TwoFactor = true
+		a.RecoveryCodes = hashes
+		return nil
+	})
+	if err != nil {
+		writeTwoFactorError(w, username, err)
+		return
+	}
+	log.Printf("🔐 Two-factor authentication enabled by %s", username)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the caller, after checking a second factor
+func RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	username := claimsFromContext(r.Context()).Username
+	var req twoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, "INVALID_BODY", "invalid body")
+		return
+	}
+
+	codes, hashes := newRecoveryCodes()
+	_, err := accounts.update(username, func(a *Account) error {
+		if err := a.checkSecondFactor(req.Code); err != nil {
+			return err
+		}
+		a.RecoveryCodes = hashes
+		return nil
+	})
+	if err != nil {
+		writeTwoFactorError(w, username, err)
+		return
+	}
+	log.Printf("🔐 Recovery codes regenerated by %s", username)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTwoFactor turns off two-factor authentication for the caller, after checking a second factor
+func DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	username := claimsFromContext(r.Context()).Username
+	var req twoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, "INVALID_BODY", "invalid body")
+		return
+	}
+
+	_, err := accounts.update(username, func(a *Account) error {
+		if err := a.checkSecondFactor(req.Code); err != nil {
+			return err
+		}
+		a.resetTwoFactor()
+		return nil
+	})
+	if err != nil {
+		writeTwoFactorError(w, username, err)
+		return
+	}
+	log.Printf("🔐 Two-factor authentication disabled by %s", username)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Two-factor authentication disabled",
+	})
+}
+
+// ResetTwoFactor turns off two-factor authentication for an operator who lost their device
+// and their recovery codes. Their sessions are closed.
+func ResetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+	_, err := accounts.update(username, func(a *Account) error {
+		a.resetTwoFactor()
+		return nil
+	})
+	if err != nil {
+		writeAccountError(w, username, err)
+		return
+	}
+	sessions.revokeUser(username)
+	log.Printf("🔐 Two-factor authentication of %s reset by %s", username, claimsFromContext(r.Context()).Username)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Two-factor authentication reset",
+	})
+}
+
+// recoveryCodesRemaining returns the number of unused recovery codes of an account,
+// which are stripped from the accounts returned by the store
+func (s *accountStore) recoveryCodesRemaining(username string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if a, ok := s.accounts[username]; ok {
+		return len(a.RecoveryCodes)
+	}
+	return 0
+}
+
+// resetTwoFactor forgets the second factor of the account
+func (a *Account) resetTwoFactor() {
+	a.TwoFactor = false
+	a.TOTPSecret = ""
+	a.TOTPLastStep = 0
+	a.RecoveryCodes = nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTOTPCode checks the SHA1 test vectors of RFC 6238, truncated to 6 digits
+func TestTOTPCode(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1234567890:  "005924",
+		20000000000: "353130",
+	}
+	for at, want := range vectors {
+		if code := totpCode(secret, at/totpPeriod); code != want {
+			t.Errorf("code at %d = %s, want %s", at, code, want)
+		}
+	}
+}
+
+func TestCheckTOTP(t *testing.T) {
+	a := &Account{TOTPSecret: newTOTPSecret()}
+	key, err := base32NoPadding.DecodeString(a.TOTPSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	step := now.Unix() / totpPeriod
+
+	if a.checkTOTP(totpCode(key, step-2), now) {
+		t.Error("a code two periods old was accepted")
+	}
+	if !a.checkTOTP(totpCode(key, step-1), now) {
+		t.Error("the code of the previous period was refused")
+	}
+	if !a.checkTOTP(totpCode(key, step), now) {
+		t.Error("the current code was refused")
+	}
+	// an accepted code, and the codes before it, cannot be replayed
+	if a.checkTOTP(totpCode(key, step), now) || a.checkTOTP(totpCode(key, step-1), now) {
+		t.Error("a code was accepted twice")
+	}
+	if a.checkTOTP(totpCode(key, step+1)[:5], now) {
+		t.Error("a truncated code was accepted")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes := newRecoveryCodes()
+	a := &Account{TwoFactor: true, TOTPSecret: newTOTPSecret(), RecoveryCodes: hashes}
+
+	if err := a.checkSecondFactor(codes[0]); err != nil {
+		t.Errorf("recovery code refused: %v", err)
+	}
+	if err := a.checkSecondFactor(codes[0]); err != errInvalidCode {
+		t.Errorf("second use of a recovery code = %v, want %v", err, errInvalidCode)
+	}
+	if err := a.checkSecondFactor(strings.ToUpper(strings.ReplaceAll(codes[1], "-", ""))); err != nil {
+		t.Errorf("recovery code without dash, in upper case, refused: %v", err)
+	}
+	if len(a.RecoveryCodes) != recoveryCodeCount-2 {
+		t.Errorf("%d recovery codes left, want %d", len(a.RecoveryCodes), recoveryCodeCount-2)
+	}
+}
+
+func TestTwoFactorRequired(t *testing.T) {
+	defer func(required []Permission) { conf.TwoFactor.RequiredFor = required }(conf.TwoFactor.RequiredFor)
+	conf.TwoFactor.RequiredFor = []Permission{PermManageAccounts}
+
+	w := checkPermission(&Claims{Role: RoleAdmin}, PermManageAccounts)
+	if w.Code != http.StatusForbidden || problemCode(t, w) != "TWO_FACTOR_REQUIRED" {
+		t.Errorf("admin without second factor: status %d, %s", w.Code, w.Body.String())
+	}
+	if w := checkPermission(&Claims{Role: RoleAdmin, MFA: true}, PermManageAccounts); w.Code != http.StatusOK {
+		t.Errorf("admin with second factor: status %d", w.Code)
+	}
+	if w := checkPermission(&Claims{Role: RoleAdmin}, PermManageLicenses); w.Code != http.StatusOK {
+		t.Errorf("admin without second factor revoking a license: status %d", w.Code)
+	}
+}
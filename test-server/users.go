@@ -0,0 +1,172 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// User is a patron known by the LCP server through its licenses
+type User struct {
+	ID           string     `json:"id"`
+	Email        string     `json:"email,omitempty"`
+	Licenses     int        `json:"licenses"`
+	LastActivity *time.Time `json:"last_activity,omitempty"`
+}
+
+// UserDetails adds license and device totals to a user
+type UserDetails struct {
+	User
+	LicenseStatuses []LicenseStatus `json:"license_statuses"`
+	ActiveLicenses  int             `json:"active_licenses"`
+	Devices         int             `json:"devices"`
+}
+
+// userStatuses is the order of the license statuses in user details
+var userStatuses = []string{StatusReady, StatusActive, StatusExpired, StatusRevoked, StatusCancelled, StatusReturned}
+
+// users aggregates the license records per user, ordered by user ID
+func users(records []licenseRecord) []*UserDetails {
+	byID := map[string]*UserDetails{}
+	for _, rec := range records {
+		user, ok := byID[rec.UserID]
+		if !ok {
+			user = &UserDetails{User: User{ID: rec.UserID}}
+			for _, status := range userStatuses {
+				user.LicenseStatuses = append(user.LicenseStatuses, LicenseStatus{Name: status})
+			}
+			byID[rec.UserID] = user
+		}
+		if user.Email == "" {
+			user.Email = rec.UserEmail
+		}
+		user.Licenses++
+		for i := range user.LicenseStatuses {
+			if user.LicenseStatuses[i].Name == rec.Status {
+				user.LicenseStatuses[i].Count++
+			}
+		}
+		if rec.Status == StatusReady || rec.Status == StatusActive {
+			user.ActiveLicenses++
+		}
+		user.Devices += rec.DeviceCount
+		if last := rec.lastActivity(); user.LastActivity == nil || last.After(*user.LastActivity) {
+			user.LastActivity = &last
+		}
+	}
+
+	list := make([]*UserDetails, 0, len(byID))
+	for _, user := range byID {
+		list = append(list, user)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// lastActivity returns the date of the latest change or event on a license
+func (rec *licenseRecord) lastActivity() time.Time {
+	last := rec.UpdatedAt
+	for _, event := range rec.Events {
+		if at, err := time.Parse(time.RFC3339, event.Timestamp); err == nil && at.After(last) {
+			last = at
+		}
+	}
+	for _, device := range rec.Devices {
+		if device.LastSeen.After(last) {
+			last = device.LastSeen
+		}
+	}
+	return last
+}
+
+// matchUser tells if a user matches the search parameters:
+// q matches part of the user ID or email, prefix matches the start of the user ID or email,
+// email matches the complete email. All comparisons are case-insensitive.
+func matchUser(user *UserDetails, q, prefix, email string) bool {
+	id := strings.ToLower(user.ID)
+	mail := strings.ToLower(user.Email)
+	if q != "" && !strings.Contains(id, q) && !strings.Contains(mail, q) {
+		return false
+	}
+	if prefix != "" && !strings.HasPrefix(id, prefix) && !strings.HasPrefix(mail, prefix) {
+		return false
+	}
+	if email != "" && mail != email {
+		return false
+	}
+	return true
+}
+
+// Users searches the user directory
+func Users(w http.ResponseWriter, r *http.Request) {
+	page := r.Context().Value(PageKey).(int)
+	perPage := r.Context().Value(PerPageKey).(int)
+
+	query := r.URL.Query()
+	q := strings.ToLower(strings.TrimSpace(query.Get("q")))
+	prefix := strings.ToLower(strings.TrimSpace(query.Get("prefix")))
+	email := strings.ToLower(strings.TrimSpace(query.Get("email")))
+
+	p := privacyFor(r.Context())
+	userID := ""
+	if p.hide {
+		// Partial matches would disclose the hidden identifiers and emails one character at a time:
+		// without PII clearance, only a pseudonym or a complete email can be searched
+		if strings.HasPrefix(q, pseudonymPrefix) {
+			userID, q = resolveUserID(r.Context(), q), ""
+		}
+		if q != "" || prefix != "" {
+			writeProblem(w, http.StatusForbidden, "PII_CLEARANCE_REQUIRED",
+				"searching part of a user identifier or email requires access to personal data; search a pseudonym or a complete email")
+			return
+		}
+	}
+
+	found := []User{}
+	for _, user := range users(store.list(r.Context(), nil)) {
+		if (userID == "" || user.ID == userID) && matchUser(user, q, prefix, email) {
+			found = append(found, p.user(user.User))
+		}
+	}
+
+	// Simple pagination logic
+	start := (page - 1) * perPage
+	end := start + perPage
+	if start > len(found) {
+		start = len(found)
+	}
+	if end > len(found) {
+		end = len(found)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(found[start:end])
+}
+
+// UserDetail returns the totals of a user
+func UserDetail(w http.ResponseWriter, r *http.Request) {
+	userID := resolveUserID(r.Context(), chi.URLParam(r, "userID"))
+
+	list := users(store.list(r.Context(), func(rec *licenseRecord) bool { return rec.UserID == userID }))
+	if len(list) == 0 {
+		writeProblem(w, http.StatusNotFound, "USER_NOT_FOUND", fmt.Sprintf("user %s not found", userID))
+		return
+	}
+
+	user := list[0]
+	user.User = privacyFor(r.Context()).user(user.User)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
@@ -0,0 +1,383 @@
+// Copyright 2025 EDRLab
+// Licensed under the BSD 3-Clause License (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the root directory of this source
+// distribution or at https://opensource.org/license/bsd-3-clause/
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Webhook event types
+const (
+	WebhookLicenseRevoked     = "license.revoked"
+	WebhookLicenseOvershared  = "license.overshared"
+	WebhookPublicationDeleted = "publication.deleted"
+	WebhookReportReady        = "report.ready"
+)
+
+var webhookEventTypes = []string{WebhookLicenseRevoked, WebhookLicenseOvershared, WebhookPublicationDeleted, WebhookReportReady}
+
+// Delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+const (
+	// webhookMaxAttempts is the number of delivery attempts before a delivery is marked as failed
+	webhookMaxAttempts = 6
+	// webhookBaseDelay is the delay before the first retry, doubled at each attempt
+	webhookBaseDelay = 2 * time.Second
+	// webhookMaxDelay caps the delay between two attempts
+	webhookMaxDelay = 5 * time.Minute
+	// webhookLogSize is the number of deliveries kept in the delivery log
+	webhookLogSize = 1000
+)
+
+// WebhookSubscription registers a target URL for a set of event types.
+// The secret used to sign the payloads is only returned on creation.
+type WebhookSubscription struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	CreatedBy string    `json:"created_by"`
+}
+
+// WebhookPayload is the JSON document posted to the subscribers
+type WebhookPayload struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// WebhookDelivery records the delivery of a payload to a subscriber
+type WebhookDelivery struct {
+	ID             string          `json:"id"`
+	SubscriptionID string          `json:"subscription_id"`
+	EventID        string          `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	LastStatusCode int             `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
+
+// webhookManager holds the subscriptions and the delivery log, and delivers the payloads
+type webhookManager struct {
+	mu            sync.Mutex
+	subscriptions map[string]*WebhookSubscription
+	deliveries    []*WebhookDelivery
+	client        *http.Client
+}
+
+var webhooks = &webhookManager{
+	subscriptions: make(map[string]*WebhookSubscription),
+	client:        &http.Client{Timeout: 10 * time.Second},
+}
+
+// dispatch creates a delivery for each subscription interested in the event type
+func (m *webhookManager) dispatch(eventType string, data interface{}) {
+	payload := WebhookPayload{
+		ID:        newID("evt"),
+		Type:      eventType,
+		Timestamp: time.Now(),
+		Data:      data,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Webhook payload of %s could not be encoded: %v", eventType, err)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, sub := range m.subscriptions {
+		if !slices.Contains(sub.Events, eventType) {
+			continue
+		}
+		delivery := &WebhookDelivery{
+			ID:             newID("dlv"),
+			SubscriptionID: sub.ID,
+			EventID:        payload.ID,
+			EventType:      eventType,
+			Payload:        body,
+			Status:         DeliveryPending,
+			CreatedAt:      time.Now(),
+		}
+		m.record(delivery)
+		go m.deliver(delivery, sub.URL, sub.Secret)
+	}
+}
+
+// record adds a delivery to the delivery log, forgetting the oldest ones.
+// It must be called with the lock held.
+func (m *webhookManager) record(delivery *WebhookDelivery) {
+	m.deliveries = append(m.deliveries, delivery)
+	if len(m.deliveries) > webhookLogSize {
+		m.deliveries = m.deliveries[len(m.deliveries)-webhookLogSize:]
+	}
+}
+
+// onActivity turns the activities of the live stream into webhook events
+func (m *webhookManager) onActivity(a Activity) {
+	switch {
+	case a.Type == ActivityStatusChanged && a.Status == StatusRevoked:
+		m.dispatch(WebhookLicenseRevoked, a)
+	case a.Type == ActivityOvershare:
+		m.dispatch(WebhookLicenseOvershared, a)
+	}
+}
+
+// deliver posts a payload until it is accepted or the maximum number of attempts is reached,
+// waiting exponentially longer between attempts
+func (m *webhookManager) deliver(d *WebhookDelivery, target string, secret string) {
+	for {
+		m.mu.Lock()
+		d.Attempts++
+		attempt := d.Attempts
+		d.NextAttemptAt = nil
+		m.mu.Unlock()
+
+		statusCode, err := m.post(target, secret, d)
+
+		m.mu.Lock()
+		d.LastStatusCode = statusCode
+		if err == nil {
+			now := time.Now()
+			d.Status = DeliveryDelivered
+			d.DeliveredAt = &now
+			d.LastError = ""
+			m.mu.Unlock()
+			return
+		}
+		d.LastError = err.Error()
+		if attempt >= webhookMaxAttempts {
+			d.Status = DeliveryFailed
+			m.mu.Unlock()
+			log.Printf("Webhook delivery %s to %s failed after %d attempts: %v", d.ID, target, attempt, err)
+			return
+		}
+		delay := webhookBaseDelay << (attempt - 1)
+		if delay > webhookMaxDelay {
+			delay = webhookMaxDelay
+		}
+		next := time.Now().Add(delay)
+		d.NextAttemptAt = &next
+		m.mu.Unlock()
+
+		time.Sleep(delay)
+	}
+}
+
+// post sends a signed payload. The signature is an HMAC-SHA256 of "{timestamp}.{body}",
+// so that the receiver can reject replayed payloads.
+func (m *webhookManager) post(target, secret string, d *WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(d.Payload)
+
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "lcp-dashboard-webhooks")
+	req.Header.Set("X-Webhook-Id", d.ID)
+	req.Header.Set("X-Webhook-Event", d.EventType)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("target returned %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// WebhookSubscriptionRequest is the body of a subscription request.
+// If no secret is provided, one is generated.
+type WebhookSubscriptionRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+// Webhooks lists the webhook subscriptions
+func Webhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks.mu.Lock()
+	list := make([]WebhookSubscription, 0, len(webhooks.subscriptions))
+	for _, sub := range webhooks.subscriptions {
+		s := *sub
+		s.Secret = ""
+		list = append(list, s)
+	}
+	webhooks.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// CreateWebhook registers a webhook subscription
+func CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req WebhookSubscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, "INVALID_BODY", "invalid webhook subscription")
+		return
+	}
+	target, err := url.Parse(req.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		writeProblem(w, http.StatusBadRequest, "INVALID_URL", "url must be an absolute http or https URL")
+		return
+	}
+	if len(req.Events) == 0 {
+		writeProblem(w, http.StatusBadRequest, "INVALID_EVENTS", fmt.Sprintf("events must list one or more of %v", webhookEventTypes))
+		return
+	}
+	for _, event := range req.Events {
+		if !slices.Contains(webhookEventTypes, event) {
+			writeProblem(w, http.StatusBadRequest, "INVALID_EVENTS", fmt.Sprintf("unknown event type %q", event))
+			return
+		}
+	}
+	if req.Secret == "" {
+		req.Secret = newID("whsec")
+	}
+
+	sub := &WebhookSubscription{
+		ID:        newID("wh"),
+		URL:       target.String(),
+		Events:    req.Events,
+		Secret:    req.Secret,
+		CreatedAt: time.Now(),
+		CreatedBy: claimsFromContext(r.Context()).Username,
+	}
+	webhooks.mu.Lock()
+	webhooks.subscriptions[sub.ID] = sub
+	webhooks.mu.Unlock()
+
+	log.Printf("🪝 Webhook %s registered for %v", sub.URL, sub.Events)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(sub)
+}
+
+// DeleteWebhook removes a webhook subscription
+func DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "webhookID")
+
+	webhooks.mu.Lock()
+	_, ok := webhooks.subscriptions[id]
+	delete(webhooks.subscriptions, id)
+	webhooks.mu.Unlock()
+
+	if !ok {
+		writeProblem(w, http.StatusNotFound, "WEBHOOK_NOT_FOUND", fmt.Sprintf("webhook %s not found", id))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Webhook deleted successfully",
+	})
+}
+
+// WebhookDeliveries returns the delivery log of a subscription, latest first
+func WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "webhookID")
+
+	webhooks.mu.Lock()
+	_, ok := webhooks.subscriptions[id]
+	list := []WebhookDelivery{}
+	for i := len(webhooks.deliveries) - 1; i >= 0; i-- {
+		if d := webhooks.deliveries[i]; d.SubscriptionID == id {
+			list = append(list, *d)
+		}
+	}
+	webhooks.mu.Unlock()
+
+	if !ok {
+		writeProblem(w, http.StatusNotFound, "WEBHOOK_NOT_FOUND", fmt.Sprintf("webhook %s not found", id))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// ReplayWebhookDelivery sends the payload of a past delivery again, as a new delivery
+func ReplayWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "deliveryID")
+
+	webhooks.mu.Lock()
+	var original *WebhookDelivery
+	for _, d := range webhooks.deliveries {
+		if d.ID == id {
+			original = d
+			break
+		}
+	}
+	var sub *WebhookSubscription
+	if original != nil {
+		sub = webhooks.subscriptions[original.SubscriptionID]
+	}
+	if original == nil || sub == nil {
+		webhooks.mu.Unlock()
+		writeProblem(w, http.StatusNotFound, "DELIVERY_NOT_FOUND", fmt.Sprintf("delivery %s not found", id))
+		return
+	}
+	replay := &WebhookDelivery{
+		ID:             newID("dlv"),
+		SubscriptionID: original.SubscriptionID,
+		EventID:        original.EventID,
+		EventType:      original.EventType,
+		Payload:        original.Payload,
+		Status:         DeliveryPending,
+		CreatedAt:      time.Now(),
+	}
+	webhooks.record(replay)
+	webhooks.mu.Unlock()
+
+	go webhooks.deliver(replay, sub.URL, sub.Secret)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(replay)
+}